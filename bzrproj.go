@@ -0,0 +1,224 @@
+package prj
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type BzrProject struct {
+	foreignProject
+}
+
+var _ Project = &BzrProject{}
+
+func ContainsBzrProject(dir string) (ok bool, err error) {
+	if !filepath.IsAbs(dir) {
+		return false, fmt.Errorf("prj: input %q is not absolute", dir)
+	}
+	return containsBzrProjectUnchecked(dir)
+}
+
+func containsBzrProjectUnchecked(dir string) (ok bool, err error) {
+	if _, err = os.Stat(filepath.Join(dir, ".bzr", "branch")); err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func LoadBzrProject(path string) (*BzrProject, error) {
+	id, err := bzrReadID(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BzrProject{foreignProject{path: path, id: id, kind: ProjectBzr}}, nil
+}
+
+// bzrReadID uses the revision ID of the first revision, which is the same in
+// every branch of the project. Revision IDs contain the committer's email and
+// the date, so if the repository contains unrelated histories, the lowest
+// sorts first. The ID is empty if there are no revisions yet.
+//
+// Only the revision indexes are read, to find the revision without parents;
+// the repository formats used since bzr 0.92 ('pack' and '2a') are supported,
+// as are 'knit' repositories.
+func bzrReadID(path string) (string, error) {
+	repo, err := bzrRepositoryDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	var roots []string
+	indexes, err := filepath.Glob(filepath.Join(repo, "indices", "*.rix"))
+	if err != nil {
+		return "", err
+	}
+	for _, index := range indexes {
+		found, err := bzrIndexRoots(index)
+		if err != nil {
+			return "", fmt.Errorf("prj: could not read bzr index %q: %w", index, err)
+		}
+		roots = append(roots, found...)
+	}
+
+	if len(indexes) == 0 {
+		knit := filepath.Join(repo, "revisions.kndx")
+		if _, err := os.Stat(knit); os.IsNotExist(err) {
+			return "", fmt.Errorf("prj: unsupported bzr repository format in %q", repo)
+		}
+		if roots, err = bzrKnitRoots(knit); err != nil {
+			return "", fmt.Errorf("prj: could not read bzr index %q: %w", knit, err)
+		}
+	}
+
+	var id string
+	for _, root := range roots {
+		if id == "" || root < id {
+			id = root
+		}
+	}
+	return id, nil
+}
+
+// bzrRepositoryDir finds the repository the branch in 'path' keeps its
+// revisions in. It's either in the branch, or in a shared repository in one
+// of its parents. A lightweight checkout refers to a branch elsewhere.
+func bzrRepositoryDir(path string) (string, error) {
+	location := filepath.Join(path, ".bzr", "branch", "location")
+	if bts, err := ioutil.ReadFile(location); err == nil {
+		u, err := url.Parse(strings.TrimSpace(string(bts)))
+		if err != nil {
+			return "", err
+		} else if u.Scheme != "file" {
+			return "", fmt.Errorf("prj: bzr checkout %q refers to a branch that isn't local: %s", path, u)
+		}
+		path = filepath.FromSlash(u.Path)
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	for cur := path; ; {
+		repo := filepath.Join(cur, ".bzr", "repository")
+		if _, err := os.Stat(repo); err == nil {
+			return repo, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		next := filepath.Dir(cur)
+		if next == cur {
+			return "", fmt.Errorf("prj: bzr repository for %q not found", path)
+		}
+		cur = next
+	}
+}
+
+const (
+	bzrGraphIndexSignature = "Bazaar Graph Index 1\n"
+	bzrBTreeSignature      = "B+Tree Graph Index 2\n"
+	bzrBTreePageSize       = 4096
+	bzrBTreeHeaderLines    = 5
+)
+
+// bzrIndexRoots returns the keys in a revision index that have no parents.
+func bzrIndexRoots(file string) (roots []string, err error) {
+	bts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(bts, []byte(bzrBTreeSignature)):
+		return bzrBTreeRoots(bts)
+	case bytes.HasPrefix(bts, []byte(bzrGraphIndexSignature)):
+		return bzrGraphIndexRoots(bts)
+	default:
+		return nil, fmt.Errorf("unsupported index format")
+	}
+}
+
+// bzrBTreeRoots reads a B+Tree index, which is made of zlib-compressed
+// pages, the first of which starts with an uncompressed header. Leaf pages
+// hold lines of 'key \0 parents \0 value'.
+func bzrBTreeRoots(bts []byte) (roots []string, err error) {
+	for offset := 0; offset < len(bts); offset += bzrBTreePageSize {
+		end := offset + bzrBTreePageSize
+		if end > len(bts) {
+			end = len(bts)
+		}
+		page := bts[offset:end]
+		if offset == 0 {
+			for i := 0; i < bzrBTreeHeaderLines; i++ {
+				nl := bytes.IndexByte(page, '\n')
+				if nl < 0 {
+					return nil, fmt.Errorf("truncated header")
+				}
+				page = page[nl+1:]
+			}
+		}
+
+		zr, err := zlib.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+		node, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, err
+		}
+
+		const leafHeader = "type=leaf\n"
+		if !bytes.HasPrefix(node, []byte(leafHeader)) {
+			continue
+		}
+		for _, line := range bytes.Split(node[len(leafHeader):], []byte{'\n'}) {
+			fields := bytes.SplitN(line, []byte{0}, 3)
+			if len(fields) == 3 && len(fields[1]) == 0 {
+				roots = append(roots, string(fields[0]))
+			}
+		}
+	}
+	return roots, nil
+}
+
+// bzrGraphIndexRoots reads the older, uncompressed index. After the header,
+// each line is 'key \0 absent \0 parents \0 value'. Absent keys are parents
+// that aren't in the index, so they have no parents of their own listed.
+func bzrGraphIndexRoots(bts []byte) (roots []string, err error) {
+	lines := bytes.Split(bts, []byte{'\n'})
+	if len(lines) < 4 {
+		return nil, fmt.Errorf("truncated header")
+	}
+	for _, line := range lines[4:] {
+		fields := bytes.SplitN(line, []byte{0}, 4)
+		if len(fields) == 4 && len(fields[1]) == 0 && len(fields[2]) == 0 {
+			roots = append(roots, string(fields[0]))
+		}
+	}
+	return roots, nil
+}
+
+// bzrKnitRoots reads a knit index, where each line is 'key options position
+// size parents... :'.
+func bzrKnitRoots(file string) (roots []string, err error) {
+	bts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(bts), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 5 && fields[4] == ":" {
+			roots = append(roots, fields[0])
+		}
+	}
+	return roots, nil
+}
@@ -0,0 +1,61 @@
+package prj
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/bzr/btree.rix is a single page B+Tree index. btree-multi.rix has
+// an internal page and two leaf pages, the last of which is short, as the
+// last page in a real index is. graph.rix is the older GraphIndex, including
+// a ghost (a parent that isn't in the repository), and knit.kndx is a knit
+// index.
+
+func TestBzrIndexRoots(t *testing.T) {
+	for idx, tc := range []struct {
+		file  string
+		roots []string
+	}{
+		{file: "btree.rix", roots: []string{"bob@x-20080101-aaa", "zed@x-20070101-ccc"}},
+		{file: "btree-multi.rix", roots: []string{"ann@x-20100101-a1", "mike@x-20100101-b1"}},
+		{file: "graph.rix", roots: []string{"sue@x-20050101-r1"}},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			roots, err := bzrIndexRoots(filepath.Join("testdata", "bzr", tc.file))
+			if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if strings.Join(roots, ",") != strings.Join(tc.roots, ",") {
+				t.Fatalf("%d: expected roots %v, found %v", idx, tc.roots, roots)
+			}
+		})
+	}
+}
+
+func TestBzrIndexRootsInvalid(t *testing.T) {
+	for idx, tc := range []struct {
+		fn  func([]byte) ([]string, error)
+		in  string
+		err string
+	}{
+		{fn: bzrBTreeRoots, in: bzrBTreeSignature + "node_ref_lists=1\n", err: "truncated header"},
+		{fn: bzrBTreeRoots, in: bzrBTreeSignature + "a\nb\nc\nd\nnot zlib", err: "zlib"},
+		{fn: bzrGraphIndexRoots, in: bzrGraphIndexSignature + "node_ref_lists=1\n", err: "truncated header"},
+	} {
+		_, err := tc.fn([]byte(tc.in))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("%d: expected error containing %q, found %v", idx, tc.err, err)
+		}
+	}
+}
+
+func TestBzrKnitRoots(t *testing.T) {
+	roots, err := bzrKnitRoots(filepath.Join("testdata", "bzr", "knit.kndx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(roots, ",") != "rev-k1" {
+		t.Fatalf("unexpected roots %v", roots)
+	}
+}
@@ -0,0 +1,137 @@
+package prj
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ProjectCandidate describes a directory that isn't a project of any
+// registered kind, but looks like it should be one. See ScanSuggest.
+type ProjectCandidate struct {
+	// What made the directory look like a project, i.e. "Ableton Live set".
+	Reason string
+
+	// The file that matched, relative to the directory.
+	File string
+
+	// A name for the project, suitable for 'prj init'. It comes from the
+	// session or manifest file if there is one, or the directory otherwise.
+	Name string
+}
+
+type candidateRule struct {
+	reason string
+
+	// Glob pattern matched against the names in the directory.
+	pattern string
+
+	// If set, the directory must also contain this directory.
+	withDir string
+
+	// If set, reads the name of the project from the matched file; returns
+	// an empty string if it can't.
+	name func(file string) string
+}
+
+// candidateRules are checked in order; the first rule that matches wins.
+var candidateRules = []candidateRule{
+	{reason: "Ableton Live set", pattern: "*.als", name: candidateNameFromFile},
+	{reason: "Logic Pro project", pattern: "*.logicx", name: candidateNameFromFile},
+	{reason: "Pro Tools session", pattern: "*.ptx", name: candidateNameFromFile},
+	{reason: "REAPER project", pattern: "*.rpp", name: candidateNameFromFile},
+	{reason: "Visual Studio solution", pattern: "*.sln", name: candidateNameFromFile},
+	{reason: "Rust crate", pattern: "Cargo.toml", name: candidateNameFromCargo},
+	{reason: "Go module", pattern: "go.mod", name: candidateNameFromGoMod},
+	{reason: "Makefile and src directory", pattern: "Makefile", withDir: "src"},
+}
+
+// detectCandidate returns a ProjectCandidate if 'dir' matches one of the
+// candidateRules, or nil.
+func detectCandidate(dir string) (*ProjectCandidate, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// So the same file is picked every time if several match:
+	sort.Strings(names)
+
+	for _, rule := range candidateRules {
+		for _, name := range names {
+			if ok, _ := path.Match(strings.ToLower(rule.pattern), strings.ToLower(name)); !ok {
+				continue
+			}
+			if rule.withDir != "" {
+				if info, err := os.Stat(filepath.Join(dir, rule.withDir)); err != nil || !info.IsDir() {
+					continue
+				}
+			}
+
+			candidate := &ProjectCandidate{Reason: rule.reason, File: name}
+			if rule.name != nil {
+				candidate.Name = rule.name(filepath.Join(dir, name))
+			}
+			if candidate.Name == "" {
+				_, candidate.Name = filepath.Split(strings.TrimRight(dir, string(filepath.Separator)))
+			}
+			return candidate, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func candidateNameFromFile(file string) string {
+	_, name := filepath.Split(file)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+var (
+	cargoPackagePattern = regexp.MustCompile(`(?m)^\s*\[package\]\s*$`)
+	cargoSectionPattern = regexp.MustCompile(`(?m)^\s*\[`)
+	cargoNamePattern    = regexp.MustCompile(`(?m)^\s*name\s*=\s*"([^"]+)"`)
+	goModulePattern     = regexp.MustCompile(`(?m)^\s*module\s+"?([^"\s]+)"?`)
+)
+
+// candidateNameFromCargo uses the name from the [package] section. A
+// workspace doesn't have one.
+func candidateNameFromCargo(file string) string {
+	bts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	loc := cargoPackagePattern.FindIndex(bts)
+	if loc == nil {
+		return ""
+	}
+	section := bts[loc[1]:]
+	if next := cargoSectionPattern.FindIndex(section); next != nil {
+		section = section[:next[0]]
+	}
+	if match := cargoNamePattern.FindSubmatch(section); match != nil {
+		return string(match[1])
+	}
+	return ""
+}
+
+// candidateNameFromGoMod uses the last element of the module path.
+func candidateNameFromGoMod(file string) string {
+	bts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	if match := goModulePattern.FindSubmatch(bts); match != nil {
+		return path.Base(string(match[1]))
+	}
+	return ""
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	prj "github.com/shabbyrobe/prj"
+)
+
+const compareUsage = cmdy.DefaultUsage + `
+Each side of the comparison can be:
+
+  - The root of a 'prj' project; its current state is compared.
+  - A 'prj' project root followed by '@' and a mark, i.e. '/backup/foo@abc123';
+    the state recorded by the mark is compared. Marks can also be given as '~N'
+    or a date, as with 'prj diff -from'.
+  - Any other directory; it is hashed as if it were a project.
+
+Files only in <a> are shown as 'A', files only in <b> are shown as 'D'. Files in
+<a> with the same content as a file at a different path in <b> are shown as 'R'
+(renamed) or 'C' (copied).
+`
+
+type compareCommand struct {
+	a, b     string
+	all      bool
+	paranoid bool
+	workers  int
+}
+
+func (cmd *compareCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Compare two projects, directories or marks",
+		Usage:    compareUsage,
+		Examples: cmdy.Examples{
+			{Desc: "Compare two copies of a project", Command: "/mnt/backup1/foo /mnt/backup2/foo"},
+			{Desc: "Compare a directory against a mark", Command: "/mnt/unsorted/foo ~/foo@sha512:abc"},
+		},
+	}
+}
+
+func (cmd *compareCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.BoolVar(&cmd.all, "all", false, "Print identical files too")
+	flags.BoolVar(&cmd.paranoid, "paranoid", false, "Ignore the hash cache and re-hash every file")
+	flags.IntVar(&cmd.workers, "j", 0, "Number of files to hash concurrently (defaults to the number of CPUs)")
+	args.String(&cmd.a, "a", "Project, directory or project@mark")
+	args.String(&cmd.b, "b", "Project, directory or project@mark")
+}
+
+type compareSide struct {
+	path    string
+	mark    string
+	project *prj.SimpleProject // nil if this side is a raw directory
+}
+
+func parseCompareSide(in string) (*compareSide, error) {
+	side := &compareSide{path: in}
+
+	if _, err := os.Stat(in); os.IsNotExist(err) {
+		if idx := strings.LastIndex(in, "@"); idx > 0 {
+			side.path, side.mark = in[:idx], in[idx+1:]
+		}
+	}
+
+	path, err := filepath.Abs(side.path)
+	if err != nil {
+		return nil, err
+	}
+	side.path = path
+
+	if ok, err := prj.ContainsSimpleProject(side.path); err != nil {
+		return nil, err
+	} else if ok {
+		if side.project, err = prj.LoadSimpleProject(side.path); err != nil {
+			return nil, err
+		}
+	} else if side.mark != "" {
+		return nil, fmt.Errorf("prj: %q is not a project, so it has no marks", side.path)
+	}
+
+	return side, nil
+}
+
+func (side *compareSide) status(ctx cmdy.Context, like *prj.SimpleProject, algo prj.HashAlgorithm, options *prj.StatusOptions) (status *prj.ProjectStatus, done func(), err error) {
+	done = func() {}
+
+	if side.project != nil {
+		if side.mark != "" {
+			status, _, err = side.project.ResolveMarkStatus(side.mark)
+			return status, done, err
+		}
+		status, err = side.project.Status(ctx, "", time.Now(), options)
+		return status, done, err
+	}
+
+	var initOptions []prj.InitOption
+	if like != nil {
+		initOptions = append(initOptions,
+			prj.InitWithSubProjectPolicy(like.SubProjectPolicy()))
+	}
+	if algo != "" {
+		initOptions = append(initOptions, prj.InitWithHashAlgorithm(algo))
+	}
+	project, _, done, err := loadTemporaryProject(ctx, side.path, initOptions...)
+	if err != nil {
+		return nil, done, err
+	}
+	status, err = project.Status(ctx, "", time.Now(), options)
+	return status, done, err
+}
+
+func (cmd *compareCommand) Run(ctx cmdy.Context) error {
+	a, err := parseCompareSide(cmd.a)
+	if err != nil {
+		return err
+	}
+	b, err := parseCompareSide(cmd.b)
+	if err != nil {
+		return err
+	}
+
+	// Raw directories need to be hashed with the same algorithm and
+	// sub-project policy as the project or mark they're being compared to, so
+	// the other side must be resolved first:
+	like := a.project
+	if like == nil {
+		like = b.project
+	}
+
+	options := &prj.StatusOptions{
+		Paranoid: cmd.paranoid,
+		Workers:  cmd.workers,
+	}
+
+	sides := []*compareSide{a, b}
+	statuses := make([]*prj.ProjectStatus, len(sides))
+
+	var algo prj.HashAlgorithm
+	for i, side := range sides {
+		if side.project == nil {
+			continue
+		}
+		status, done, err := side.status(ctx, like, "", options)
+		defer done()
+		if err != nil {
+			return err
+		}
+		if algo == "" {
+			algo = status.Hash.Algorithm
+		} else if algo != status.Hash.Algorithm {
+			return fmt.Errorf("prj: sides use different hash algorithms (%s, %s); use 'prj rehash' to migrate one of them",
+				algo, status.Hash.Algorithm)
+		}
+		statuses[i] = status
+	}
+
+	for i, side := range sides {
+		if side.project != nil {
+			continue
+		}
+		status, done, err := side.status(ctx, like, algo, options)
+		defer done()
+		if err != nil {
+			return err
+		}
+		statuses[i] = status
+	}
+
+	aStatus, bStatus := statuses[0], statuses[1]
+
+	diff, err := aStatus.CompareTo(bStatus)
+	if err != nil {
+		return err
+	}
+
+	out := ctx.Stdout()
+	for _, item := range diff.Items() {
+		if cmd.all || item.Status != prj.DiffSame {
+			printDiffItem(out, item)
+		}
+	}
+
+	aSizes := fileSizes(aStatus)
+	bSizes := fileSizes(bStatus)
+
+	var onlyA, onlyB int64
+	for _, p := range diff.Added {
+		onlyA += aSizes[p]
+	}
+	for _, p := range diff.Removed {
+		onlyB += bSizes[p]
+	}
+
+	fmt.Fprintf(out, ""+
+		"\n"+
+		"only in a: %d file(s), %s\n"+
+		"only in b: %d file(s), %s\n"+
+		"modified:  %d file(s)\n"+
+		"renamed:   %d file(s)\n"+
+		"copied:    %d file(s)\n"+
+		"identical: %d file(s)\n",
+		len(diff.Added), bytesHuman(onlyA, 3),
+		len(diff.Removed), bytesHuman(onlyB, 3),
+		len(diff.Modified),
+		len(diff.Renamed),
+		len(diff.Copied),
+		len(diff.Same))
+
+	return nil
+}
+
+func fileSizes(status *prj.ProjectStatus) map[prj.ResourcePath]int64 {
+	sizes := make(map[prj.ResourcePath]int64, len(status.Files))
+	for _, f := range status.Files {
+		sizes[f.Name] = f.Size
+	}
+	return sizes
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/shabbyrobe/cmdy"
@@ -10,9 +11,14 @@ import (
 )
 
 type diffCommand struct {
-	path  string
-	stats bool
-	all   bool
+	app      *App
+	path     string
+	from     string
+	to       string
+	stats    bool
+	all      bool
+	paranoid bool
+	workers  int
 }
 
 func (cmd *diffCommand) Help() cmdy.Help {
@@ -23,24 +29,53 @@ func (cmd *diffCommand) Help() cmdy.Help {
 				Desc:    "Show all files, including identical",
 				Command: "-all",
 			},
+			{
+				Desc:    "Show what changed between the mark before last and the last mark",
+				Command: "-from '~1' -to '~0'",
+			},
+			{
+				Desc:    "Show what changed since the last mark made on or before a date",
+				Command: "-from 2020-06-01",
+			},
 		},
 	}
 }
 
 func (cmd *diffCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.StringVar(&cmd.from, "from", "", "Mark to compare from (defaults to the last mark). "+markRefHelp)
+	flags.StringVar(&cmd.to, "to", worktreeRef, "Mark to compare to, or '"+worktreeRef+"' for the current state of the project. "+markRefHelp)
 	flags.BoolVar(&cmd.stats, "stats", false, "Print some stats at the end")
 	flags.BoolVar(&cmd.all, "all", false, "Print identical files too")
+	flags.BoolVar(&cmd.paranoid, "paranoid", false, "Ignore the hash cache and re-hash every file")
+	flags.IntVar(&cmd.workers, "j", 0, "Number of files to hash concurrently (defaults to the number of CPUs)")
 	args.StringOptional(&cmd.path, "path", "", "Limit status check to child path, if passed")
 }
 
 func (cmd *diffCommand) Run(ctx cmdy.Context) error {
-	project, _, err := loadSimpleProject("")
+	project, _, err := loadProject("", cmd.app.priority)
 	if err != nil {
 		return err
 	}
 
 	start := time.Now()
-	diff, err := project.Diff(ctx, prj.NewResourcePath(cmd.path), time.Now())
+	options := &prj.StatusOptions{
+		Paranoid: cmd.paranoid,
+		Workers:  cmd.workers,
+	}
+
+	to := cmd.to
+	if to == worktreeRef {
+		to = ""
+	}
+
+	var diff *prj.ProjectDiff
+	if cmd.from == "" && to == "" {
+		diff, err = project.Diff(ctx, prj.NewResourcePath(cmd.path), time.Now(), options)
+	} else if simple, ok := project.(*prj.SimpleProject); ok {
+		diff, err = simple.DiffMarks(ctx, prj.NewResourcePath(cmd.path), cmd.from, to, time.Now(), options)
+	} else {
+		return fmt.Errorf("-from and -to are only supported for %s projects", prj.ProjectSimple)
+	}
 	if err != nil {
 		return err
 	}
@@ -51,13 +86,23 @@ func (cmd *diffCommand) Run(ctx cmdy.Context) error {
 	items := diff.Items()
 	for _, item := range items {
 		if cmd.all || item.Status != prj.DiffSame {
-			fmt.Fprintf(out, " %c %s\n", item.Status, item.Path)
+			printDiffItem(out, item)
 		}
 	}
 
-	if cmd.stats {
+	if cmd.stats && to == "" {
+		stats := diff.Current.Stats
 		fmt.Fprintln(ctx.Stderr(), "\ntime taken:", taken)
+		fmt.Fprintf(ctx.Stderr(), "cache:      %d hit(s), %d miss(es)\n", stats.CacheHits, stats.CacheMisses)
 	}
 
 	return nil
 }
+
+func printDiffItem(out io.Writer, item prj.ProjectDiffItem) {
+	if item.From != "" {
+		fmt.Fprintf(out, " %c %s -> %s\n", item.Status, item.From, item.Path)
+	} else {
+		fmt.Fprintf(out, " %c %s\n", item.Status, item.Path)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	"github.com/shabbyrobe/golib/errtools"
+	"github.com/shabbyrobe/prj"
+)
+
+const dupesUsage = cmdy.DefaultUsage + `
+Projects are copies of each other if they have the same kind and ID. Copies
+are compared by the hash of their last mark (or commit), and the newest copy
+is the one with the latest mark:
+
+  - 'newest' is the copy with the latest mark.
+  - 'identical' copies have the same hash as the newest.
+  - 'behind' copies have a hash that appears in the newest copy's log, so
+    they are an older state of the newest rather than a fork of it.
+  - 'diverged' copies have a hash that isn't in the newest copy's log;
+    copies that have diverged the same way are listed together.
+
+If no paths are passed, the index built by 'prj index build' is used instead
+of searching the filesystem.
+`
+
+type dupesCommand struct {
+	app    *App
+	paths  []string
+	kinds  prj.ProjectKindSet
+	nested bool
+}
+
+func (cmd *dupesCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Find copies of the same project",
+		Usage:    dupesUsage,
+		Examples: cmdy.Examples{
+			{Desc: "Find copies in the index", Command: ""},
+			{Desc: "Find copies across backup disks", Command: "/mnt/backup1 /mnt/backup2"},
+		},
+	}
+}
+
+func (cmd *dupesCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.Var(&cmd.kinds, "kind", "Only show these kinds, all by default. Can pass multiple times. ("+prj.ProjectKindsHelp()+")")
+	flags.BoolVar(&cmd.nested, "nested", false, "Find nested projects (i.e. .git within .git). Ignored when using the index.")
+	args.Remaining(&cmd.paths, "paths", arg.AnyLen, "List of paths to search for projects. Uses the index if empty")
+}
+
+func (cmd *dupesCommand) Run(ctx cmdy.Context) error {
+	if cmd.kinds.Count() == 0 {
+		cmd.kinds.SetAll()
+	}
+
+	var entries []*IndexEntry
+	var failed []*prj.FoundProject
+	if len(cmd.paths) == 0 {
+		idx, err := loadIndex(cmd.app.IndexFile())
+		if err != nil {
+			return err
+		}
+		entries = idx.Entries
+
+	} else {
+		var err error
+		entries, failed, err = scanIndexEntries(ctx, cmd.paths, cmd.nested, cmd.app.priority)
+		if err != nil {
+			return err
+		}
+	}
+
+	groups := groupDupes(entries, cmd.kinds)
+
+	var logFailed []error
+	for _, group := range groups {
+		if err := group.findBehind(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logFailed = append(logFailed, err)
+		}
+	}
+
+	out := ctx.Stdout()
+	w := tabwriter.NewWriter(out, 2, 2, 2, ' ', 0)
+	for i, group := range groups {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s (%s %s): %d copies, %d version(s)\n",
+			group.Name, group.Kind, group.ID, group.Copies(), len(group.Versions))
+
+		for vi, version := range group.Versions {
+			for ci, entry := range version {
+				status := "identical"
+				if vi == 0 && ci == 0 {
+					status = "newest"
+				} else if vi > 0 && group.Behind[vi] {
+					status = fmt.Sprintf("behind (%d)", vi)
+				} else if vi > 0 {
+					status = fmt.Sprintf("diverged (%d)", vi)
+				}
+
+				lastMod := "<none>"
+				if !entry.ModTime.IsZero() {
+					lastMod = entry.ModTime.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", status, lastMod, shortHash(entry.Hash), entry.Path)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 || len(logFailed) > 0 {
+		fmt.Fprintln(out)
+		for _, fprj := range failed {
+			fmt.Fprintf(out, "ERROR: could not load %q: %v\n", fprj.Path, fprj.Err)
+		}
+		for _, err := range logFailed {
+			fmt.Fprintf(out, "ERROR: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+type dupeGroup struct {
+	Kind string
+	ID   string
+	Name string
+
+	// Copies grouped by hash. The first version contains the newest copy,
+	// which is always first. Other versions are ordered newest first.
+	Versions [][]*IndexEntry
+
+	// True for each version whose hash appears in the newest copy's log.
+	// Set by findBehind.
+	Behind []bool
+}
+
+// findBehind reads the newest copy's log to find which of the other versions
+// it has been through. If the log can't be read, every other version is
+// assumed to have diverged.
+func (g *dupeGroup) findBehind(ctx context.Context) (rerr error) {
+	g.Behind = make([]bool, len(g.Versions))
+	if len(g.Versions) < 2 {
+		return nil
+	}
+
+	want := map[string]int{}
+	for vi, version := range g.Versions[1:] {
+		if hash := version[0].Hash; !hash.IsEmpty() {
+			want[hash.String()] = vi + 1
+		}
+	}
+	if len(want) == 0 {
+		return nil
+	}
+
+	newest := g.Versions[0][0]
+	var kind prj.ProjectKind
+	if err := kind.Set(newest.Kind); err != nil {
+		return err
+	}
+	project, err := kind.Load(newest.Path)
+	if err != nil {
+		return fmt.Errorf("could not read the log of %q: %w", newest.Path, err)
+	}
+
+	iter := project.Log()
+	defer errtools.DeferClose(&rerr, iter)
+
+	var entry prj.LogEntry
+	for len(want) > 0 && iter.Next(&entry) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hash := entry.Hash.String()
+		if vi, ok := want[hash]; ok {
+			g.Behind[vi] = true
+			delete(want, hash)
+		}
+	}
+	return nil
+}
+
+func (g *dupeGroup) Copies() (n int) {
+	for _, v := range g.Versions {
+		n += len(v)
+	}
+	return n
+}
+
+// groupDupes groups entries with the same kind and ID. Projects without an
+// ID (i.e. an empty hg repo) can't be matched, and groups with only one copy
+// are left out.
+func groupDupes(entries []*IndexEntry, kinds prj.ProjectKindSet) []*dupeGroup {
+	byID := map[string][]*IndexEntry{}
+	for _, entry := range entries {
+		var kind prj.ProjectKind
+		if err := kind.Set(entry.Kind); err != nil || !kinds[kind] || entry.ID == "" {
+			continue
+		}
+		key := entry.Kind + ":" + entry.ID
+		byID[key] = append(byID[key], entry)
+	}
+
+	var groups []*dupeGroup
+	for _, copies := range byID {
+		if len(copies) < 2 {
+			continue
+		}
+
+		sort.Slice(copies, func(i, j int) bool {
+			if !copies[i].ModTime.Equal(copies[j].ModTime) {
+				return copies[i].ModTime.After(copies[j].ModTime)
+			}
+			return copies[i].Path < copies[j].Path
+		})
+
+		newest := copies[0]
+		group := &dupeGroup{Kind: newest.Kind, ID: newest.ID, Name: newest.Name}
+
+		versionIdx := map[string]int{}
+		for _, entry := range copies {
+			hash := entry.Hash.String()
+			idx, ok := versionIdx[hash]
+			if !ok {
+				idx = len(group.Versions)
+				versionIdx[hash] = idx
+				group.Versions = append(group.Versions, nil)
+			}
+			group.Versions[idx] = append(group.Versions[idx], entry)
+		}
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].ID < groups[j].ID
+	})
+
+	return groups
+}
+
+// scanIndexEntries searches 'paths' for projects, and returns them as if
+// they had been read from the index.
+func scanIndexEntries(ctx cmdy.Context, paths []string, nested bool, priority prj.RootPriority) (entries []*IndexEntry, failed []*prj.FoundProject, err error) {
+	opts := []prj.ScanOption{
+		prj.ScanExcludePattern(defaultScanExcludes()...),
+		prj.ScanWithPriority(priority),
+	}
+	if nested {
+		opts = append(opts, prj.ScanNested())
+	}
+
+	seen := map[string]bool{}
+
+	now := time.Now()
+	for _, path := range paths {
+		// Make the paths absolute so overlapping paths find the same projects
+		// under the same names:
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil, err
+		}
+
+		scn := prj.Scan(path, opts...)
+		for scn.Next() {
+			if err := ctx.Err(); err != nil {
+				scn.Close()
+				return nil, nil, err
+			}
+
+			found := scn.Current()
+			if seen[found.Path] { // Paths may overlap
+				continue
+			}
+			seen[found.Path] = true
+
+			if found.Project == nil {
+				failed = append(failed, found)
+				continue
+			}
+
+			entry, err := newIndexEntry(ctx, found.Path, found.Project, now)
+			if err != nil {
+				failed = append(failed, &prj.FoundProject{Path: found.Path, Kind: found.Kind, Err: err})
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		if err := scn.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return entries, failed, nil
+}
+
+func shortHash(hash prj.Hash) string {
+	if hash.IsEmpty() {
+		return "<none>"
+	}
+	v := hash.Value.String()
+	if len(v) > 12 {
+		v = v[:12]
+	}
+	return string(hash.Algorithm) + ":" + v
+}
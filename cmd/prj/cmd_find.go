@@ -5,6 +5,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/shabbyrobe/cmdy"
@@ -14,10 +15,12 @@ import (
 )
 
 type findCommand struct {
+	app              *App
 	paths            []string
 	showID           bool
 	showHash         bool
 	nested           bool
+	suggest          bool
 	kinds            prj.ProjectKindSet
 	exclude          flags.StringList
 	noDefaultExclude bool
@@ -26,20 +29,11 @@ type findCommand struct {
 func (cmd *findCommand) Help() cmdy.Help { return cmdy.Synopsis("Find projects on the filesystem") }
 
 func (cmd *findCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
-	kinds := ""
-	for i := prj.ProjectKind(0); int(i) < prj.ProjectKindSize; i++ {
-		if i.IsValid() {
-			if kinds != "" {
-				kinds += ", "
-			}
-			kinds += i.String()
-		}
-	}
-
 	flags.BoolVar(&cmd.showID, "id", false, "Show ID")
 	flags.BoolVar(&cmd.showHash, "hash", false, "Show Hash")
 	flags.BoolVar(&cmd.nested, "nested", false, "Find nested projects (i.e. .git within .git)")
-	flags.Var(&cmd.kinds, "kind", "Show these kinds, all by default. Can pass multiple times. ("+kinds+")")
+	flags.BoolVar(&cmd.suggest, "suggest", false, "Also list folders that look like projects but aren't, i.e. a folder containing a DAW session, Cargo.toml or go.mod")
+	flags.Var(&cmd.kinds, "kind", "Show these kinds, all by default. Can pass multiple times. ("+prj.ProjectKindsHelp()+")")
 	flags.Var(&cmd.exclude, "exclude", "List of regexps to exclude. Must include anchors if desired. `/` matches `\\` as well.")
 	flags.BoolVar(&cmd.noDefaultExclude, "no-default-exclude", false, "Exclude the default list of exclude paths")
 	args.Remaining(&cmd.paths, "paths", arg.AnyLen, "List of paths to search for projects. Uses CWD if empty")
@@ -62,16 +56,8 @@ func (cmd *findCommand) Run(ctx cmdy.Context) error {
 
 	var exclude = []string{}
 
-	// FIXME: add this to a global configuration
 	if !cmd.noDefaultExclude {
-		exclude = append(exclude,
-			`/\.cargo\/`,
-			`/\.cache\/`,
-			`/\.npm\/`,
-		)
-		if gopath := os.Getenv("GOPATH"); gopath != "" {
-			exclude = append(exclude, regexp.QuoteMeta(gopath))
-		}
+		exclude = append(exclude, defaultScanExcludes()...)
 	}
 
 	// FIXME: this is yet another experiment to make tabular CLI layout code
@@ -137,11 +123,14 @@ func (cmd *findCommand) Run(ctx cmdy.Context) error {
 	}
 	fmt.Fprintf(out, hdrTpl, row...)
 
-	var failed []*prj.FoundProject
-	var opts []prj.ScanOption
+	var failed, candidates []*prj.FoundProject
+	var opts = []prj.ScanOption{prj.ScanWithPriority(cmd.app.priority)}
 	if cmd.nested {
 		opts = append(opts, prj.ScanNested())
 	}
+	if cmd.suggest {
+		opts = append(opts, prj.ScanSuggest())
+	}
 	if len(exclude) > 0 {
 		opts = append(opts, prj.ScanExcludePattern(exclude...))
 	}
@@ -151,6 +140,10 @@ func (cmd *findCommand) Run(ctx cmdy.Context) error {
 		for scn.Next() {
 			found := scn.Current()
 
+			if found.Candidate != nil {
+				candidates = append(candidates, found)
+				continue
+			}
 			if found.Project == nil {
 				failed = append(failed, found)
 				continue
@@ -200,6 +193,19 @@ func (cmd *findCommand) Run(ctx cmdy.Context) error {
 		}
 	}
 
+	if len(candidates) > 0 {
+		fmt.Fprintf(out, "\nSuggested projects (not yet initialised):\n")
+		w := tabwriter.NewWriter(out, 2, 2, 2, ' ', 0)
+		for _, found := range candidates {
+			fmt.Fprintf(w, "  %s\t%s (%s)\tprj init -name %s %s\n",
+				found.Path, found.Candidate.Reason, found.Candidate.File,
+				shellQuote(found.Candidate.Name), shellQuote(found.Path))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
 	if len(failed) > 0 {
 		fmt.Fprintln(out)
 		for _, fprj := range failed {
@@ -209,3 +215,27 @@ func (cmd *findCommand) Run(ctx cmdy.Context) error {
 
 	return nil
 }
+
+// shellQuote quotes 's' for a POSIX shell if it contains anything that isn't
+// obviously safe.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("/._-+:@,", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// FIXME: add this to a global configuration
+func defaultScanExcludes() []string {
+	exclude := []string{
+		`/\.cargo\/`,
+		`/\.cache\/`,
+		`/\.npm\/`,
+	}
+	if gopath := os.Getenv("GOPATH"); gopath != "" {
+		exclude = append(exclude, regexp.QuoteMeta(gopath))
+	}
+	return exclude
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	prj "github.com/shabbyrobe/prj"
+)
+
+const fsckUsage = cmdy.DefaultUsage + `
+Checks the project's .prj directory for damage, such as the mess an
+interrupted 'prj mark' can leave behind. Problems reported:
+
+  partial-log-line  The last line of the log was not completely written
+  bad-log-line      A line in the log could not be parsed
+  missing-status    A log entry's status file does not exist
+  bad-status        A status file could not be read
+  hash-mismatch     The files in a status file don't match its log entry's hash
+  stale-config      The config's last entry does not match the log
+  orphan-status     A status file is not referred to by the log
+
+With -repair, log lines that can't be parsed are removed (and kept in
+.prj/orphans/log.jsonl), as is the last log entry if its status file is
+missing. The config is rebuilt from the log, and orphaned status files are
+moved to .prj/orphans. Other problems can only be reported.
+
+Exits with status 1 if any problems are found and not repaired.
+`
+
+type fsckCommand struct {
+	repair bool
+}
+
+func (cmd *fsckCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Check for and repair damaged project metadata",
+		Usage:    fsckUsage,
+	}
+}
+
+func (cmd *fsckCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.BoolVar(&cmd.repair, "repair", false, "Repair any problems that are found")
+}
+
+func (cmd *fsckCommand) Run(ctx cmdy.Context) error {
+	project, _, err := loadSimpleProject("")
+	if err != nil {
+		return err
+	}
+
+	result, err := project.Fsck(ctx, &prj.FsckOptions{Repair: cmd.repair})
+	if err != nil {
+		return err
+	}
+
+	out := ctx.Stdout()
+	var repairable int
+	for _, problem := range result.Problems {
+		if problem.Repaired {
+			fmt.Fprintf(out, "%s (repaired)\n", problem)
+		} else {
+			fmt.Fprintln(out, problem)
+		}
+		if problem.Repairable && !problem.Repaired {
+			repairable++
+		}
+	}
+
+	if len(result.Problems) == 0 {
+		fmt.Fprintf(out, "ok, checked %d log entries\n", result.Entries)
+		return nil
+	}
+
+	if n := result.Unrepaired(); n > 0 {
+		err := fmt.Errorf("prj: found %d problem(s)", n)
+		if repairable > 0 {
+			err = fmt.Errorf("prj: found %d problem(s), use -repair to fix %d of them", n, repairable)
+		}
+		return cmdy.ErrWithCode(1, err)
+	}
+
+	return nil
+}
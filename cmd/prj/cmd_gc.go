@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	"github.com/shabbyrobe/cmdy/flags"
+	prj "github.com/shabbyrobe/prj"
+)
+
+const gcUsage = cmdy.DefaultUsage + `
+Converts the project's status files (the list of files recorded by each mark,
+kept in .prj/status) to the compact format, which takes a fraction of the
+space. Status files written before the compact format existed can still be
+read without converting them.
+
+Marks passed to -drop, or all but the last N marks if -keep is passed, have
+their status files removed. The marks stay in the log, but can no longer be
+diffed against or restored. The status of the last mark is never removed.
+`
+
+type gcCommand struct {
+	drop flags.StringList
+	keep int
+}
+
+func (cmd *gcCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Compact status files and drop the status of old marks",
+		Usage:    gcUsage,
+		Examples: cmdy.Examples{
+			{Desc: "Convert status files to the compact format", Command: ""},
+			{Desc: "Drop the status of the 3rd last mark", Command: "-drop '~2'"},
+			{Desc: "Only keep the status of the last 10 marks", Command: "-keep 10"},
+		},
+	}
+}
+
+func (cmd *gcCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.Var(&cmd.drop, "drop", "Drop the status of this mark. Can pass multiple times. "+markRefHelp)
+	flags.IntVar(&cmd.keep, "keep", 0, "Drop the status of all but the last N marks")
+}
+
+func (cmd *gcCommand) Run(ctx cmdy.Context) error {
+	project, _, err := loadSimpleProject("")
+	if err != nil {
+		return err
+	}
+
+	if cmd.keep < 0 {
+		return cmdy.UsageErrorf("-keep must not be negative")
+	}
+
+	options := &prj.GCOptions{Keep: cmd.keep}
+	for _, ref := range cmd.drop {
+		entry, err := project.ResolveMark(ref)
+		if err != nil {
+			return err
+		}
+		options.Drop = append(options.Drop, entry)
+	}
+
+	result, err := project.GC(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(ctx.Stdout(), "converted %d status file(s), dropped the status of %d mark(s); %s -> %s\n",
+		result.Converted, result.Dropped,
+		bytesHuman(result.SizeBefore, 1), bytesHuman(result.SizeAfter, 1))
+
+	return nil
+}
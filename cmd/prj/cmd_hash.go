@@ -10,12 +10,18 @@ import (
 )
 
 const hashUsage = cmdy.DefaultUsage + `
-NOTE: this does not yet work with Git or Mercurial projects.
+NOTE: this does not yet work with Mercurial projects. Git projects are hashed
+using git's own blob hashes, so they will not match a 'prj' hash of the same
+files.
 `
 
 type hashCommand struct {
-	child   string
-	rawPath string
+	app      *App
+	child    string
+	rawPath  string
+	stats    bool
+	paranoid bool
+	workers  int
 }
 
 func (cmd *hashCommand) Help() cmdy.Help {
@@ -27,11 +33,14 @@ func (cmd *hashCommand) Help() cmdy.Help {
 
 func (cmd *hashCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
 	flags.StringVar(&cmd.rawPath, "raw", "", "Hash path at -raw, even if it is not a 'prj' project")
+	flags.BoolVar(&cmd.stats, "stats", false, "Print some stats at the end")
+	flags.BoolVar(&cmd.paranoid, "paranoid", false, "Ignore the hash cache and re-hash every file")
+	flags.IntVar(&cmd.workers, "j", 0, "Number of files to hash concurrently (defaults to the number of CPUs)")
 	args.StringOptional(&cmd.child, "child", "", "Limit status check to child path, if passed")
 }
 
 func (cmd *hashCommand) Run(ctx cmdy.Context) error {
-	project, _, done, err := loadSimpleProjectWithTemporaryFallback(ctx, "", cmd.rawPath)
+	project, _, done, err := loadProjectWithTemporaryFallback(ctx, "", cmd.rawPath, cmd.app.priority)
 	if err != nil {
 		return err
 	}
@@ -39,8 +48,13 @@ func (cmd *hashCommand) Run(ctx cmdy.Context) error {
 
 	path := prj.NewResourcePath(cmd.child)
 
+	options := &prj.StatusOptions{
+		Paranoid: cmd.paranoid,
+		Workers:  cmd.workers,
+	}
+
 	start := time.Now()
-	status, err := project.Status(ctx, path, start)
+	status, err := project.Status(ctx, path, start, options)
 	if err != nil {
 		return err
 	}
@@ -66,5 +80,9 @@ func (cmd *hashCommand) Run(ctx cmdy.Context) error {
 		bytesHuman(status.Size, 3), status.Size, len(status.Files),
 		taken)
 
+	if cmd.stats {
+		fmt.Fprintf(out, "cache:    %d hit(s), %d miss(es)\n", status.Stats.CacheHits, status.Stats.CacheMisses)
+	}
+
 	return nil
 }
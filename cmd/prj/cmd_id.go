@@ -5,22 +5,27 @@ import (
 
 	"github.com/shabbyrobe/cmdy"
 	"github.com/shabbyrobe/cmdy/arg"
-	prj "github.com/shabbyrobe/prj"
 )
 
-type idCommand struct{}
+type idCommand struct {
+	app *App
+}
 
 func (cmd *idCommand) Help() cmdy.Help { return cmdy.Synopsis("Show project ID") }
 
 func (cmd *idCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {}
 
 func (cmd *idCommand) Run(ctx cmdy.Context) error {
-	allKinds := []prj.ProjectKind{prj.ProjectSimple, prj.ProjectGit, prj.ProjectHg}
-	project, _, err := loadProject("", allKinds)
+	project, _, err := loadProject("", cmd.app.priority)
 	if err != nil {
 		return err
 	}
 
+	// Git and hg repos get their ID from their first commit:
+	if project.ID() == "" {
+		return fmt.Errorf("prj: project %q has no ID until it has a commit", project.Path())
+	}
+
 	fmt.Println(project.ID())
 
 	return nil
@@ -1,20 +1,95 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/shabbyrobe/cmdy"
 	"github.com/shabbyrobe/cmdy/arg"
 )
 
 type indexBuildCommand struct {
-	app *App
+	app         *App
+	nested      bool
+	incremental bool
 }
 
 func (cmd *indexBuildCommand) Help() cmdy.Help {
-	return cmdy.Synopsis("Index all projects found under the configured directories")
+	return cmdy.Help{
+		Synopsis: "Index all projects found under the configured directories",
+		Usage: cmdy.DefaultUsage + `
+With -incremental, directories that haven't changed since the last build are
+not read again, and 'prj' projects whose config hasn't changed are not loaded
+again. git and hg projects are always loaded again.
+
+The marks of every 'prj' project are also indexed, for 'prj which-file'.
+`,
+	}
 }
 
-func (cmd *indexBuildCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {}
+func (cmd *indexBuildCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.BoolVar(&cmd.nested, "nested", false, "Index nested projects (i.e. .git within .git)")
+	flags.BoolVar(&cmd.incremental, "incremental", false, "Only check directories that changed since the last build")
+}
 
 func (cmd *indexBuildCommand) Run(ctx cmdy.Context) error {
+	file := cmd.app.IndexFile()
+
+	// The previous index is only needed to report what changed if this
+	// isn't an incremental build, so a missing or outdated one is fine:
+	prev, err := loadIndex(file)
+	if err != nil {
+		if _, statErr := os.Stat(file); cmd.incremental && statErr == nil {
+			return err
+		}
+		prev = nil
+	}
+
+	var from *Index
+	if cmd.incremental {
+		from = prev
+	}
+
+	start := time.Now()
+	idx, failed, err := buildIndex(ctx, cmd.app.config, from, cmd.nested, start)
+	if err != nil {
+		return err
+	}
+
+	if err := idx.Save(file); err != nil {
+		return err
+	}
+
+	var prevFiles *FileIndex
+	if cmd.incremental {
+		// If this fails, the whole file index is rebuilt:
+		prevFiles, _ = loadFileIndex(cmd.app.FileIndexFile())
+	}
+	fileIdx, fileFailed, err := buildFileIndex(ctx, idx, prevFiles, start)
+	if err != nil {
+		return err
+	}
+	if err := fileIdx.Save(cmd.app.FileIndexFile()); err != nil {
+		return err
+	}
+	failed = append(failed, fileFailed...)
+
+	changes := idx.Changes(prev)
+
+	out := ctx.Stdout()
+	fmt.Fprintf(out, "indexed %d project(s) in %q in %s\n", len(idx.Entries), file, time.Since(start).Round(time.Millisecond))
+	fmt.Fprintf(out, "added: %d, updated: %d, moved: %d, removed: %d\n", changes.Added, changes.Updated, changes.Moved, changes.Removed)
+	if cmd.incremental {
+		fmt.Fprintf(out, "directories: %d unchanged, %d read\n", idx.Dirs.Hits, idx.Dirs.Misses)
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintln(out)
+		for _, fprj := range failed {
+			fmt.Fprintf(out, "ERROR: could not load %q: %v\n", fprj.Path, fprj.Err)
+		}
+	}
+
 	return nil
 }
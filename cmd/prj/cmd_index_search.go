@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	"github.com/shabbyrobe/cmdy/flags"
+	"github.com/shabbyrobe/prj"
+)
+
+type indexSearchCommand struct {
+	app    *App
+	name   string
+	id     string
+	tags   flags.StringList
+	kinds  prj.ProjectKindSet
+	format string
+}
+
+func (cmd *indexSearchCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Search the project index built by 'prj index build'",
+		Usage: cmdy.DefaultUsage + `
+All filters must match. With no filters, every indexed project is shown.
+`,
+		Examples: cmdy.Examples{
+			{Desc: "Find projects with 'song' in the name", Command: "song"},
+			{Desc: "Find git projects tagged with both 'music' and 'wip'", Command: "-kind git -tag music -tag wip"},
+			{Desc: "Find every copy of a project", Command: "-id 4f1c"},
+		},
+	}
+}
+
+func (cmd *indexSearchCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.StringVar(&cmd.id, "id", "", "Only show projects whose ID starts with this")
+	flags.Var(&cmd.tags, "tag", "Only show projects with this tag. Can pass multiple times.")
+	flags.Var(&cmd.kinds, "kind", "Only show these kinds, all by default. Can pass multiple times. ("+prj.ProjectKindsHelp()+")")
+	flags.StringVar(&cmd.format, "fmt", "table", "Output format (list, table, json)")
+	args.StringOptional(&cmd.name, "name", "", "Only show projects whose name contains this (case insensitive)")
+}
+
+func (cmd *indexSearchCommand) Run(ctx cmdy.Context) error {
+	idx, err := loadIndex(cmd.app.IndexFile())
+	if err != nil {
+		return err
+	}
+
+	if cmd.kinds.Count() == 0 {
+		cmd.kinds.SetAll()
+	}
+
+	var matched []*IndexEntry
+	for _, entry := range idx.Entries {
+		if cmd.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+
+	out := ctx.Stdout()
+	switch cmd.format {
+	case "list":
+		for _, entry := range matched {
+			fmt.Fprintln(out, entry.Path)
+		}
+
+	case "table":
+		w := tabwriter.NewWriter(out, 2, 2, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tKIND\tPROJECT NAME\tLASTMOD\tPATH\n")
+		for _, entry := range matched {
+			lastMod := "<none>"
+			if !entry.ModTime.IsZero() {
+				lastMod = entry.ModTime.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.ID, entry.Kind, entry.Name, lastMod, entry.Path)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+	case "json":
+		enc := json.NewEncoder(out)
+		for _, entry := range matched {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown -fmt")
+	}
+
+	return nil
+}
+
+func (cmd *indexSearchCommand) matches(entry *IndexEntry) bool {
+	var kind prj.ProjectKind
+	if err := kind.Set(entry.Kind); err != nil || !cmd.kinds[kind] {
+		return false
+	}
+	if cmd.id != "" && !strings.HasPrefix(entry.ID, cmd.id) {
+		return false
+	}
+	if cmd.name != "" && !strings.Contains(strings.ToLower(entry.Name), strings.ToLower(cmd.name)) {
+		return false
+	}
+
+	for _, want := range cmd.tags {
+		found := false
+		for _, tag := range entry.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -5,18 +5,18 @@ import (
 
 	"github.com/shabbyrobe/cmdy"
 	"github.com/shabbyrobe/cmdy/arg"
-	prj "github.com/shabbyrobe/prj"
 )
 
-type infoCommand struct{}
+type infoCommand struct {
+	app *App
+}
 
 func (cmd *infoCommand) Help() cmdy.Help { return cmdy.Synopsis("Show project info") }
 
 func (cmd *infoCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {}
 
 func (cmd *infoCommand) Run(ctx cmdy.Context) error {
-	allKinds := []prj.ProjectKind{prj.ProjectSimple, prj.ProjectGit, prj.ProjectHg}
-	project, _, err := loadProject("", allKinds)
+	project, _, err := loadProject("", cmd.app.priority)
 	if err != nil {
 		return err
 	}
@@ -15,6 +15,9 @@ import (
 type initCommand struct {
 	name string
 	dest string
+	algo prj.HashAlgorithm
+	subs prj.SubProjectPolicy
+	objs string
 }
 
 func (cmd *initCommand) Help() cmdy.Help {
@@ -23,6 +26,9 @@ func (cmd *initCommand) Help() cmdy.Help {
 
 func (cmd *initCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
 	flags.StringVar(&cmd.name, "name", "", "Name for this project (defaults to the last part of the directory")
+	flags.Var(&cmd.algo, "hash", "Hash algorithm for this project ("+hashAlgorithmsHelp()+"), defaults to "+prj.DefaultHashAlgorithm.String())
+	flags.Var(&cmd.subs, "subprojects", "How to treat nested projects ("+subProjectPoliciesHelp()+"), defaults to "+prj.SubProjectInclude.String())
+	flags.StringVar(&cmd.objs, "objects", "", "Keep the content of marked files so they can be restored ("+objectStoreHelp+")")
 	args.StringOptional(&cmd.dest, "dest", "", "Initialise in this destination. Uses current directory if empty.")
 }
 
@@ -53,7 +59,22 @@ func (cmd *initCommand) Run(ctx cmdy.Context) error {
 		return err
 	}
 
-	_, config, err := prj.InitSimpleProject(ctx, session, dest, name, time.Now())
+	var options []prj.InitOption
+	if cmd.algo != prj.HashNone {
+		options = append(options, prj.InitWithHashAlgorithm(cmd.algo))
+	}
+	if cmd.subs != "" {
+		options = append(options, prj.InitWithSubProjectPolicy(cmd.subs))
+	}
+	if cmd.objs != "" {
+		store, err := parseObjectStoreArg(cmd.objs)
+		if err != nil {
+			return err
+		}
+		options = append(options, prj.InitWithObjectStore(store))
+	}
+
+	_, config, err := prj.InitSimpleProject(ctx, session, dest, name, time.Now(), options...)
 	if err != nil {
 		return err
 	}
@@ -3,9 +3,8 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
 	"text/tabwriter"
+	"time"
 
 	"github.com/shabbyrobe/cmdy"
 	"github.com/shabbyrobe/cmdy/arg"
@@ -15,6 +14,7 @@ import (
 type listCommand struct {
 	child  string
 	format string
+	mark   string
 }
 
 func (cmd *listCommand) Help() cmdy.Help {
@@ -24,6 +24,7 @@ func (cmd *listCommand) Help() cmdy.Help {
 func (cmd *listCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
 	args.StringOptional(&cmd.child, "child", "", "Limit status check to child path, if passed")
 	flags.StringVar(&cmd.format, "fmt", "list", "Output format (list, table, json)")
+	flags.StringVar(&cmd.mark, "mark", "", "List files as of this mark (defaults to the last mark). "+markRefHelp)
 }
 
 func (cmd *listCommand) Run(ctx cmdy.Context) error {
@@ -32,25 +33,33 @@ func (cmd *listCommand) Run(ctx cmdy.Context) error {
 		return err
 	}
 
-	// FIXME: This kinda sucks a bit; the API in prj only lets you scan the FS
-	// for the status or get the log without the status, but the .prj directory
-	// contains a full cache of the last marked status (which is what we want)
-	entry, err := project.LastEntry()
-	if err != nil {
-		return err
-	}
-
 	var status prj.ProjectStatus
+	if cmd.mark == "" {
+		entry, err := project.LastEntry()
+		if err != nil {
+			return err
+		} else if entry == nil {
+			return fmt.Errorf("project has no marks")
+		}
+		markStatus, err := project.MarkStatus(entry)
+		if err != nil {
+			return err
+		}
+		status = *markStatus
 
-	// FIXME: yeah, as above, this very sucks:
-	bts, err := ioutil.ReadFile(filepath.Join(project.Path(), ".prj", "status", entry.StatusFile))
-	if err != nil {
-		return err
+	} else {
+		markStatus, _, err := project.ResolveMarkStatus(cmd.mark)
+		if err != nil {
+			return err
+		}
+		status = *markStatus
 	}
 
-	if err := json.Unmarshal(bts, &status); err != nil {
+	filteredStatus, err := project.FilterIgnored(&status, time.Now())
+	if err != nil {
 		return err
 	}
+	status = *filteredStatus
 
 	limit := prj.NewResourcePath(cmd.child)
 
@@ -19,6 +19,7 @@ const (
 )
 
 type logCommand struct {
+	app     *App
 	display string
 }
 
@@ -31,7 +32,7 @@ func (cmd *logCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
 }
 
 func (cmd *logCommand) Run(ctx cmdy.Context) (rerr error) {
-	project, _, err := loadSimpleProject("")
+	project, _, err := loadProject("", cmd.app.priority)
 	if err != nil {
 		return err
 	}
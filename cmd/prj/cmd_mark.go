@@ -17,8 +17,10 @@ NOTE: this does not yet work with Git or Mercurial projects.
 `
 
 type markCommand struct {
-	message string
-	force   bool
+	message  string
+	force    bool
+	paranoid bool
+	workers  int
 }
 
 func (cmd *markCommand) Help() cmdy.Help {
@@ -31,6 +33,8 @@ func (cmd *markCommand) Help() cmdy.Help {
 func (cmd *markCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
 	flags.StringVar(&cmd.message, "m", "", "Mark message")
 	flags.BoolVar(&cmd.force, "f", false, "Force mark")
+	flags.BoolVar(&cmd.paranoid, "paranoid", false, "Ignore the hash cache and re-hash every file")
+	flags.IntVar(&cmd.workers, "j", 0, "Number of files to hash concurrently (defaults to the number of CPUs)")
 }
 
 func (cmd *markCommand) Run(ctx cmdy.Context) error {
@@ -57,6 +61,10 @@ func (cmd *markCommand) Run(ctx cmdy.Context) error {
 
 	options := &prj.MarkOptions{
 		Force: cmd.force,
+		StatusOptions: &prj.StatusOptions{
+			Paranoid: cmd.paranoid,
+			Workers:  cmd.workers,
+		},
 	}
 
 	status, err := project.Mark(ctx, session, cmd.message, time.Now(), options)
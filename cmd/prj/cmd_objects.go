@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	prj "github.com/shabbyrobe/prj"
+)
+
+const objectStoreHelp = "'" + prj.ObjectStoreLocal + "' for .prj/objects, a directory shared between projects, or '" + objectStoreOff + "'"
+
+const objectStoreOff = "off"
+
+const objectsUsage = cmdy.DefaultUsage + `
+When the object store is enabled, 'prj mark' keeps a copy of the content of
+every file, so 'prj restore' can put it back. Each distinct file is only
+stored once, so unchanged files don't take up more space with each mark, and
+projects that share a store share identical files.
+
+Stores:
+  local   Keep objects in this project's .prj/objects directory
+  <dir>   Keep objects in a directory that can be shared between projects
+  off     Stop storing objects; objects that were already stored are kept
+
+Only files marked after the store is enabled can be restored.
+`
+
+type objectsCommand struct {
+	store string
+}
+
+func (cmd *objectsCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Show or change where the content of marked files is kept",
+		Usage:    objectsUsage,
+	}
+}
+
+func (cmd *objectsCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	args.StringOptional(&cmd.store, "store", "", "Set the store ("+objectStoreHelp+")")
+}
+
+func (cmd *objectsCommand) Run(ctx cmdy.Context) error {
+	project, _, err := loadSimpleProject("")
+	if err != nil {
+		return err
+	}
+
+	if cmd.store != "" {
+		store, err := parseObjectStoreArg(cmd.store)
+		if err != nil {
+			return err
+		}
+		if err := project.SetObjectStore(store); err != nil {
+			return err
+		}
+	}
+
+	store, err := project.ObjectStore()
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		fmt.Fprintln(ctx.Stdout(), objectStoreOff)
+	} else {
+		fmt.Fprintln(ctx.Stdout(), store.Root())
+	}
+
+	return nil
+}
+
+func parseObjectStoreArg(arg string) (string, error) {
+	switch arg {
+	case objectStoreOff:
+		return "", nil
+	case prj.ObjectStoreLocal:
+		return prj.ObjectStoreLocal, nil
+	default:
+		return filepath.Abs(arg)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	prj "github.com/shabbyrobe/prj"
+)
+
+const rehashUsage = cmdy.DefaultUsage + `
+Switches the project to a new hash algorithm. Marks are migrated by hashing the
+files currently in the project with both algorithms. Marks that refer to file
+contents that no longer exist can't be migrated; if there are any, nothing is
+changed unless '-force' is passed, in which case they are left as they are and
+can't be compared with the new marks. The last mark must always be migrated, so
+if any file has changed since then, run 'prj mark' first.
+`
+
+type rehashCommand struct {
+	algo     prj.HashAlgorithm
+	force    bool
+	paranoid bool
+	workers  int
+}
+
+func (cmd *rehashCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Migrate the project to a different hash algorithm",
+		Usage:    rehashUsage,
+	}
+}
+
+func (cmd *rehashCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.BoolVar(&cmd.force, "force", false, "Leave marks that can't be migrated in the old algorithm")
+	flags.BoolVar(&cmd.paranoid, "paranoid", false, "Ignore the hash cache and re-hash every file")
+	flags.IntVar(&cmd.workers, "j", 0, "Number of files to hash concurrently (defaults to the number of CPUs)")
+	args.Var(&cmd.algo, "algo", "Hash algorithm ("+hashAlgorithmsHelp()+")")
+}
+
+func (cmd *rehashCommand) Run(ctx cmdy.Context) error {
+	project, _, err := loadSimpleProject("")
+	if err != nil {
+		return err
+	}
+
+	options := &prj.RehashOptions{
+		Force: cmd.force,
+		StatusOptions: &prj.StatusOptions{
+			Paranoid: cmd.paranoid,
+			Workers:  cmd.workers,
+		},
+	}
+
+	result, err := project.Rehash(ctx, cmd.algo, time.Now(), options)
+	if err != nil {
+		return err
+	}
+
+	out := ctx.Stdout()
+	fmt.Fprintf(out, "rehashed from %s to %s; %d mark(s) migrated\n", result.From, result.To, result.Migrated)
+	if len(result.Unmigrated) > 0 {
+		fmt.Fprintf(out, "\n%d mark(s) could not be migrated:\n", len(result.Unmigrated))
+		for _, entry := range result.Unmigrated {
+			fmt.Fprintf(out, "  %s  %s\n", entry.Time.Format(time.RFC3339), truncate(entry.Message, 50))
+		}
+	}
+
+	return nil
+}
+
+func hashAlgorithmsHelp() string {
+	var algos []string
+	for _, algo := range prj.HashAlgorithms() {
+		algos = append(algos, algo.String())
+	}
+	return strings.Join(algos, ", ")
+}
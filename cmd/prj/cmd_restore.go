@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	prj "github.com/shabbyrobe/prj"
+)
+
+const restoreUsage = cmdy.DefaultUsage + `
+Files are restored from the project's object store (see 'prj objects'). Files
+that aren't in the mark are never removed. Files that exist but differ from
+the mark are left alone unless -overwrite is passed.
+`
+
+type restoreCommand struct {
+	path      string
+	mark      string
+	overwrite bool
+}
+
+func (cmd *restoreCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Restore files from a mark",
+		Usage:    restoreUsage,
+		Examples: cmdy.Examples{
+			{Desc: "Restore deleted files from the last mark", Command: ""},
+			{Desc: "Put a directory back the way it was two marks ago", Command: "-mark '~2' -overwrite samples/"},
+		},
+	}
+}
+
+func (cmd *restoreCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.StringVar(&cmd.mark, "mark", "", "Mark to restore (defaults to the last mark). "+markRefHelp)
+	flags.BoolVar(&cmd.overwrite, "overwrite", false, "Replace files that differ from the mark")
+	args.StringOptional(&cmd.path, "path", "", "Only restore this file or directory")
+}
+
+func (cmd *restoreCommand) Run(ctx cmdy.Context) error {
+	project, _, err := loadSimpleProject("")
+	if err != nil {
+		return err
+	}
+
+	var entry *prj.LogEntry
+	if cmd.mark == "" {
+		if entry, err = project.LastEntry(); err != nil {
+			return err
+		} else if entry == nil {
+			return fmt.Errorf("project has no marks")
+		}
+	} else if entry, err = project.ResolveMark(cmd.mark); err != nil {
+		return err
+	}
+
+	result, err := project.Restore(ctx, entry, prj.NewResourcePath(cmd.path), time.Now(), &prj.RestoreOptions{
+		Overwrite: cmd.overwrite,
+	})
+	if err != nil {
+		return err
+	}
+
+	out := ctx.Stdout()
+	for _, p := range result.Restored {
+		fmt.Fprintf(out, " R %s\n", p)
+	}
+	for _, p := range result.Conflicts {
+		fmt.Fprintf(out, " ! %s (differs from mark, use -overwrite)\n", p)
+	}
+	for _, p := range result.Missing {
+		fmt.Fprintf(out, " ? %s (not in object store)\n", p)
+	}
+
+	fmt.Fprintf(out, "\nrestored: %d, unchanged: %d, conflicts: %d, missing: %d\n",
+		len(result.Restored), len(result.Unchanged), len(result.Conflicts), len(result.Missing))
+
+	if len(result.Missing) > 0 || len(result.Conflicts) > 0 {
+		return cmdy.ErrWithCode(1, fmt.Errorf("some files could not be restored"))
+	}
+	return nil
+}
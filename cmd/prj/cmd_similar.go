@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	"github.com/shabbyrobe/prj"
+)
+
+const similarUsage = cmdy.DefaultUsage + `
+The files in <dir> are hashed, then compared to the files recorded by the last
+mark (or commit) of each project in the index built by 'prj index build'.
+Projects are ranked by the Jaccard index of the two sets of file contents,
+i.e. the number of distinct files they share divided by the number of
+distinct files in either. File names are not compared, so renamed and moved
+files still count. Empty files are ignored.
+
+hg projects are not included, as the index does not hold their file hashes.
+`
+
+type similarCommand struct {
+	app      *App
+	dir      string
+	limit    int
+	paranoid bool
+	workers  int
+}
+
+func (cmd *similarCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Find indexed projects with similar contents to a directory",
+		Usage:    similarUsage,
+	}
+}
+
+func (cmd *similarCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.IntVar(&cmd.limit, "n", 10, "Show at most this many projects")
+	flags.BoolVar(&cmd.paranoid, "paranoid", false, "Ignore the hash cache and re-hash every file")
+	flags.IntVar(&cmd.workers, "j", 0, "Number of files to hash concurrently (defaults to the number of CPUs)")
+	args.String(&cmd.dir, "dir", "Directory to find similar projects for")
+}
+
+type similarResult struct {
+	entry  *IndexEntry
+	shared int
+	score  float64
+}
+
+func (cmd *similarCommand) Run(ctx cmdy.Context) error {
+	idx, err := loadIndex(cmd.app.IndexFile())
+	if err != nil {
+		return err
+	}
+
+	dir, err := filepath.Abs(cmd.dir)
+	if err != nil {
+		return err
+	}
+
+	var algos []prj.HashAlgorithm
+	seenAlgos := map[prj.HashAlgorithm]bool{}
+	for _, entry := range idx.Entries {
+		if len(entry.Files) > 0 && !seenAlgos[entry.FilesAlgorithm] {
+			seenAlgos[entry.FilesAlgorithm] = true
+			algos = append(algos, entry.FilesAlgorithm)
+		}
+	}
+
+	options := &prj.StatusOptions{
+		Paranoid: cmd.paranoid,
+		Workers:  cmd.workers,
+	}
+	files, err := similarFileHashes(ctx, dir, algos, options)
+	if err != nil {
+		return err
+	}
+
+	var results []similarResult
+	for _, entry := range idx.Entries {
+		if entry.Path == dir || len(entry.Files) == 0 {
+			continue
+		}
+		mine := files[entry.FilesAlgorithm]
+		if len(mine) == 0 {
+			continue
+		}
+
+		shared := 0
+		for _, h := range entry.Files {
+			if mine[h] {
+				shared++
+			}
+		}
+		if shared == 0 {
+			continue
+		}
+
+		union := len(mine) + len(entry.Files) - shared
+		results = append(results, similarResult{
+			entry:  entry,
+			shared: shared,
+			score:  float64(shared) / float64(union),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].entry.Path < results[j].entry.Path
+	})
+	if cmd.limit > 0 && len(results) > cmd.limit {
+		results = results[:cmd.limit]
+	}
+
+	out := ctx.Stdout()
+	if len(results) == 0 {
+		fmt.Fprintln(out, "no similar projects found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 2, 2, 2, ' ', 0)
+	fmt.Fprintf(w, "SCORE\tSHARED\tKIND\tPROJECT NAME\tPATH\n")
+	for _, r := range results {
+		fmt.Fprintf(w, "%.1f%%\t%d/%d\t%s\t%s\t%s\n",
+			r.score*100, r.shared, len(r.entry.Files), r.entry.Kind, r.entry.Name, r.entry.Path)
+	}
+	return w.Flush()
+}
+
+// similarFileHashes hashes the non-empty files in 'dir' with each algorithm
+// in 'algos', in the form used by the index. The directory is hashed as a
+// temporary project (respecting '.prjignore') with the first algorithm, and
+// the same files are re-hashed with the rest.
+func similarFileHashes(ctx cmdy.Context, dir string, algos []prj.HashAlgorithm, options *prj.StatusOptions) (map[prj.HashAlgorithm]map[string]bool, error) {
+	result := map[prj.HashAlgorithm]map[string]bool{}
+	if len(algos) == 0 {
+		return result, nil
+	}
+
+	// Creatable algorithms first, so the temporary project can use one of them:
+	sort.SliceStable(algos, func(i, j int) bool {
+		return algos[i].CanCreate() && !algos[j].CanCreate()
+	})
+
+	statusAlgo := algos[0]
+	if !statusAlgo.CanCreate() {
+		statusAlgo = prj.DefaultHashAlgorithm
+	}
+
+	project, _, done, err := loadTemporaryProject(ctx, dir, prj.InitWithHashAlgorithm(statusAlgo))
+	defer done()
+	if err != nil {
+		return nil, err
+	}
+	status, err := project.Status(ctx, "", time.Now(), options)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, algo := range algos {
+		hashes := map[string]bool{}
+		for _, f := range status.Files {
+			if f.Size == 0 {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			hash := f.Hash
+			if algo != statusAlgo {
+				file := filepath.Join(dir, string(f.Name))
+				if algo == prj.HashGitSHA1 {
+					hash, err = prj.HashGitBlob(file)
+				} else if algo.CanCreate() {
+					hash, err = algo.HashFile(file)
+				} else {
+					break // Can't hash with this algorithm; nothing will match.
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			hashes[indexFileHash(hash)] = true
+		}
+		result[algo] = hashes
+	}
+
+	return result, nil
+}
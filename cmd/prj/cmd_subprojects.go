@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	prj "github.com/shabbyrobe/prj"
+)
+
+const subProjectsUsage = cmdy.DefaultUsage + `
+Policies:
+  include  Nested projects are hashed like any other directory (default)
+  skip     Nested projects are left out of this project
+  opaque   Each nested project is a single entry carrying its last hash, so a
+           mark records which version of each nested project it contained
+`
+
+type subProjectsCommand struct {
+	policy prj.SubProjectPolicy
+}
+
+func (cmd *subProjectsCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Show or change how nested projects are treated",
+		Usage:    subProjectsUsage,
+	}
+}
+
+func (cmd *subProjectsCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	args.VarOptional(&cmd.policy, "policy", "Set the policy ("+subProjectPoliciesHelp()+")")
+}
+
+func (cmd *subProjectsCommand) Run(ctx cmdy.Context) error {
+	project, _, err := loadSimpleProject("")
+	if err != nil {
+		return err
+	}
+
+	if cmd.policy != "" {
+		if err := project.SetSubProjectPolicy(cmd.policy); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(ctx.Stdout(), project.SubProjectPolicy())
+
+	return nil
+}
+
+func subProjectPoliciesHelp() string {
+	var policies []string
+	for _, p := range prj.SubProjectPolicies() {
+		policies = append(policies, p.String())
+	}
+	return strings.Join(policies, ", ")
+}
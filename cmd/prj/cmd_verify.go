@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+	prj "github.com/shabbyrobe/prj"
+)
+
+const verifyUsage = cmdy.DefaultUsage + `
+Every file is re-hashed (the hash cache is not used) and compared to the last
+mark. Problems reported:
+
+  ROT      The content changed, but the size and modification time did not.
+           Nobody changed this file on purpose; the disk did.
+  MISSING  The file is in the last mark, but no longer exists.
+  BAD      A file in .prj/status could not be read.
+
+Files that were modified in the usual way are listed with -modified, but are
+not counted as problems; use 'prj diff' to see them.
+
+Exits with status 1 if any problems are found.
+`
+
+type verifyCommand struct {
+	app      *App
+	paths    []string
+	index    bool
+	modified bool
+	workers  int
+}
+
+func (cmd *verifyCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Check projects for bit-rot and missing files",
+		Usage:    verifyUsage,
+		Examples: cmdy.Examples{
+			{Desc: "Verify the project in the current directory", Command: ""},
+			{Desc: "Verify several projects", Command: "/mnt/archive/foo /mnt/archive/bar"},
+			{Desc: "Verify every 'prj' project in the index", Command: "-index"},
+		},
+	}
+}
+
+func (cmd *verifyCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	flags.BoolVar(&cmd.index, "index", false, "Verify every 'prj' project in the index built by 'prj index build'")
+	flags.BoolVar(&cmd.modified, "modified", false, "List modified files too")
+	flags.IntVar(&cmd.workers, "j", 0, "Number of files to hash concurrently (defaults to the number of CPUs)")
+	args.Remaining(&cmd.paths, "paths", arg.AnyLen, "Projects to verify. Uses the current project if empty")
+}
+
+func (cmd *verifyCommand) Run(ctx cmdy.Context) error {
+	paths := cmd.paths
+	if cmd.index {
+		idx, err := loadIndex(cmd.app.IndexFile())
+		if err != nil {
+			return err
+		}
+		for _, entry := range idx.Entries {
+			if entry.Kind == prj.ProjectSimple.String() {
+				paths = append(paths, entry.Path)
+			}
+		}
+	} else if len(paths) == 0 {
+		paths = []string{""}
+	}
+
+	out := ctx.Stdout()
+	options := &prj.StatusOptions{Workers: cmd.workers}
+
+	var problems int
+	for i, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(paths) > 1 {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintf(out, "%s:\n", path)
+		}
+
+		n, err := cmd.verify(ctx, path, options)
+		if err != nil {
+			fmt.Fprintf(out, " ERROR %v\n", err)
+			n++
+		}
+		problems += n
+	}
+
+	if problems > 0 {
+		return cmdy.ErrWithCode(1, fmt.Errorf("%d problem(s) found", problems))
+	}
+	return nil
+}
+
+func (cmd *verifyCommand) verify(ctx cmdy.Context, path string, options *prj.StatusOptions) (problems int, err error) {
+	if path != "" {
+		if path, err = filepath.Abs(path); err != nil {
+			return 0, err
+		}
+	}
+
+	project, _, err := loadSimpleProject(path)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := project.Verify(ctx, time.Now(), options)
+	if err != nil {
+		return 0, err
+	}
+
+	out := ctx.Stdout()
+	for _, p := range result.Rotted {
+		fmt.Fprintf(out, " ROT     %s\n", p)
+	}
+	for _, p := range result.Missing {
+		fmt.Fprintf(out, " MISSING %s\n", p)
+	}
+	for _, bad := range result.BadStatusFiles {
+		fmt.Fprintf(out, " BAD     %s: %v\n", bad.File, bad.Err)
+	}
+	if cmd.modified {
+		for _, p := range result.Modified {
+			fmt.Fprintf(out, " M       %s\n", p)
+		}
+	}
+
+	if result.Entry == nil {
+		fmt.Fprintln(out, " project has no marks, nothing to verify")
+	} else {
+		fmt.Fprintf(out, " %d file(s) checked against mark %s from %s, %d modified\n",
+			result.Checked, shortHash(result.Entry.Hash), result.Entry.Time.Format(time.RFC3339), len(result.Modified))
+	}
+
+	problems = len(result.Rotted) + len(result.Missing) + len(result.BadStatusFiles)
+	if problems > 0 && len(result.Rotted)+len(result.Missing) > 0 {
+		if store, err := project.ObjectStore(); err == nil && store != nil {
+			fmt.Fprintln(out, " use 'prj restore -overwrite' to restore damaged files from the object store")
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shabbyrobe/cmdy"
+	"github.com/shabbyrobe/cmdy/arg"
+)
+
+const whichFileUsage = cmdy.DefaultUsage + `
+The file is hashed with each algorithm used by the indexed projects, then
+looked up in the index of every file in every mark built by 'prj index build'.
+Only 'prj' projects are searched. Empty files are not indexed.
+
+Each result shows the newest mark containing the file at that path, and how
+many marks in total contained it.
+`
+
+type whichFileCommand struct {
+	app  *App
+	file string
+}
+
+func (cmd *whichFileCommand) Help() cmdy.Help {
+	return cmdy.Help{
+		Synopsis: "Find which projects contain a file",
+		Usage:    whichFileUsage,
+	}
+}
+
+func (cmd *whichFileCommand) Configure(flags *cmdy.FlagSet, args *arg.ArgSet) {
+	args.String(&cmd.file, "file", "File to look for")
+}
+
+type whichFileResult struct {
+	projectPath string
+	project     *FileIndexProject
+	loc         FileIndexLocation
+	newest      FileIndexMark
+}
+
+func (cmd *whichFileCommand) Run(ctx cmdy.Context) error {
+	info, err := os.Stat(cmd.file)
+	if err != nil {
+		return err
+	} else if info.IsDir() {
+		return fmt.Errorf("%q is a directory", cmd.file)
+	} else if info.Size() == 0 {
+		return fmt.Errorf("%q is empty; empty files are not indexed", cmd.file)
+	}
+
+	idx, err := loadFileIndex(cmd.app.FileIndexFile())
+	if err != nil {
+		return err
+	}
+
+	var hashes []string
+	for _, algo := range idx.Algorithms {
+		if !algo.CanCreate() {
+			continue
+		}
+		hash, err := algo.HashFile(cmd.file)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, hash.String())
+	}
+
+	var results []whichFileResult
+	for path, project := range idx.Projects {
+		for _, hash := range hashes {
+			for _, loc := range project.Files[hash] {
+				results = append(results, whichFileResult{
+					projectPath: path,
+					project:     project,
+					loc:         loc,
+					newest:      project.Marks[loc.Marks[len(loc.Marks)-1]],
+				})
+			}
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].newest.Time.Equal(results[j].newest.Time) {
+			return results[i].newest.Time.After(results[j].newest.Time)
+		}
+		if results[i].projectPath != results[j].projectPath {
+			return results[i].projectPath < results[j].projectPath
+		}
+		return results[i].loc.Path < results[j].loc.Path
+	})
+
+	out := ctx.Stdout()
+	if len(results) == 0 {
+		fmt.Fprintln(out, "not found in any marked project")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 2, 2, 2, ' ', 0)
+	fmt.Fprintf(w, "PROJECT NAME\tPROJECT PATH\tMARK\tMARK TIME\tMARKS\tFILE\n")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d/%d\t%s\n",
+			r.project.Name, r.projectPath,
+			shortHash(r.newest.Hash), r.newest.Time.Format(time.RFC3339),
+			len(r.loc.Marks), len(r.project.Marks),
+			r.loc.Path)
+	}
+	return w.Flush()
+}
@@ -1,16 +1,83 @@
 package main
 
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	prj "github.com/shabbyrobe/prj"
+)
+
 type IndexPath struct {
 	// Tilde expansion of homedir is supported
 	Path string
 
+	// If true, projects under Path are added to the index by 'prj index
+	// build'. If false, Path is excluded, which allows parts of an included
+	// path to be skipped.
 	Included bool
 }
 
+// Expand returns the absolute path, with '~' expanded to the user's home
+// directory.
+func (ip IndexPath) Expand() (string, error) {
+	path := ip.Path
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return filepath.Abs(path)
+}
+
 type Config struct {
 	IndexPaths []IndexPath
+
+	// Kinds of project to look for when finding the project a directory
+	// belongs to, highest priority first, i.e. ["prj", "git"]. Defaults to
+	// every kind, 'prj' first.
+	RootPriority []string
+
+	// How RootPriority is used: "priority" (the default) looks for each kind
+	// in the directory and all of its parents before trying the next kind;
+	// "nearest" uses the nearest project, and RootPriority only breaks ties.
+	RootMode string
 }
 
 func (c *Config) Validate() error {
+	for _, ip := range c.IndexPaths {
+		if ip.Path == "" {
+			return fmt.Errorf("IndexPaths entry has an empty Path")
+		}
+	}
+	if _, err := c.Priority(); err != nil {
+		return err
+	}
 	return nil
 }
+
+// Priority returns the prj.RootPriority described by RootPriority and
+// RootMode.
+func (c *Config) Priority() (priority prj.RootPriority, err error) {
+	if len(c.RootPriority) == 0 {
+		priority.Kinds = prj.ProjectKinds()
+	}
+	for _, name := range c.RootPriority {
+		var kind prj.ProjectKind
+		if err := kind.Set(name); err != nil {
+			return priority, fmt.Errorf("RootPriority: %w (%s)", err, prj.ProjectKindsHelp())
+		}
+		priority.Kinds = append(priority.Kinds, kind)
+	}
+
+	if c.RootMode != "" {
+		if err := priority.Mode.Set(c.RootMode); err != nil {
+			return priority, fmt.Errorf("RootMode: %w", err)
+		}
+	}
+
+	return priority, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/shabbyrobe/prj"
+)
+
+const fileIndexVersion = 1
+
+// FileIndex maps the hash of every file recorded by every mark of the indexed
+// simple projects back to where it was found. It's built alongside the
+// project index by 'prj index build'.
+type FileIndex struct {
+	Version    int
+	Built      time.Time
+	Algorithms []prj.HashAlgorithm
+
+	// Keyed by project path.
+	Projects map[string]*FileIndexProject
+}
+
+type FileIndexProject struct {
+	ID   string
+	Name string
+
+	// Copied from the IndexEntry; see IndexEntry.ConfigModTime.
+	ConfigModTime time.Time `json:",omitempty"`
+	ConfigSize    int64     `json:",omitempty"`
+
+	// Every mark in the project's log, oldest first.
+	Marks []FileIndexMark
+
+	// Keyed by the file's full hash string, i.e. "sha512:...".
+	Files map[string][]FileIndexLocation
+}
+
+type FileIndexMark struct {
+	Hash prj.Hash
+	Time time.Time
+}
+
+type FileIndexLocation struct {
+	Path prj.ResourcePath
+
+	// Indexes into FileIndexProject.Marks of each mark that contained the
+	// file with this hash at this path.
+	Marks []int
+}
+
+func (app *App) FileIndexFile() string {
+	return filepath.Join(app.cachePath, "files.json")
+}
+
+func loadFileIndex(file string) (*FileIndex, error) {
+	bts, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("file index %q not found, use 'prj index build' to create it", file)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var idx FileIndex
+	if err := json.Unmarshal(bts, &idx); err != nil {
+		return nil, fmt.Errorf("file index %q could not be read: %w", file, err)
+	}
+	if idx.Version != fileIndexVersion {
+		return nil, fmt.Errorf("file index %q has version %d, expected %d; use 'prj index build' to recreate it", file, idx.Version, fileIndexVersion)
+	}
+	return &idx, nil
+}
+
+func (idx *FileIndex) Save(file string) error {
+	return writeJSONFile(file, idx)
+}
+
+// buildFileIndex reads the marks of every simple project in 'projects'. If
+// 'prev' is not nil, projects whose config hasn't changed since 'prev' was
+// built are copied from it rather than read again; a project's config changes
+// whenever it is marked.
+func buildFileIndex(ctx context.Context, projects *Index, prev *FileIndex, at time.Time) (idx *FileIndex, failed []*prj.FoundProject, err error) {
+	idx = &FileIndex{
+		Version:  fileIndexVersion,
+		Built:    at,
+		Projects: map[string]*FileIndexProject{},
+	}
+
+	for _, entry := range projects.Entries {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if entry.Kind != prj.ProjectSimple.String() {
+			continue
+		}
+
+		if prev != nil && !entry.ConfigModTime.IsZero() {
+			if old := prev.Projects[entry.Path]; old != nil &&
+				old.ConfigModTime.Equal(entry.ConfigModTime) &&
+				old.ConfigSize == entry.ConfigSize {
+				idx.Projects[entry.Path] = old
+				continue
+			}
+		}
+
+		fip, err := newFileIndexProject(entry)
+		if err != nil {
+			failed = append(failed, &prj.FoundProject{Path: entry.Path, Kind: prj.ProjectSimple, Err: err})
+			continue
+		}
+		idx.Projects[entry.Path] = fip
+	}
+
+	algos := map[prj.HashAlgorithm]bool{}
+	for _, fip := range idx.Projects {
+		for _, mark := range fip.Marks {
+			algos[mark.Hash.Algorithm] = true
+		}
+	}
+	for algo := range algos {
+		idx.Algorithms = append(idx.Algorithms, algo)
+	}
+	sort.Slice(idx.Algorithms, func(i, j int) bool { return idx.Algorithms[i] < idx.Algorithms[j] })
+
+	return idx, failed, nil
+}
+
+func newFileIndexProject(entry *IndexEntry) (*FileIndexProject, error) {
+	project, err := prj.LoadSimpleProject(entry.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	fip := &FileIndexProject{
+		ID:            entry.ID,
+		Name:          entry.Name,
+		ConfigModTime: entry.ConfigModTime,
+		ConfigSize:    entry.ConfigSize,
+		Files:         map[string][]FileIndexLocation{},
+	}
+
+	iter := project.Log()
+
+	// Position of each (hash, path) pair in fip.Files, so a file that is
+	// unchanged across many marks only gets one location:
+	locations := map[string]map[prj.ResourcePath]int{}
+
+	var logEntry prj.LogEntry
+	for iter.Next(&logEntry) {
+		if logEntry.StatusFile == "" {
+			continue
+		}
+		status, err := project.MarkStatus(&logEntry)
+		if err != nil {
+			iter.Close()
+			return nil, err
+		}
+
+		markIdx := len(fip.Marks)
+		fip.Marks = append(fip.Marks, FileIndexMark{Hash: logEntry.Hash, Time: logEntry.Time})
+
+		for _, f := range status.Files {
+			if f.Size == 0 || f.SubProject != nil || f.Hash.IsEmpty() {
+				continue // Empty files all share a hash; sub-projects aren't files.
+			}
+
+			key := f.Hash.String()
+			paths := locations[key]
+			if paths == nil {
+				paths = map[prj.ResourcePath]int{}
+				locations[key] = paths
+			}
+
+			if pos, ok := paths[f.Name]; ok {
+				loc := &fip.Files[key][pos]
+				loc.Marks = append(loc.Marks, markIdx)
+			} else {
+				paths[f.Name] = len(fip.Files[key])
+				fip.Files[key] = append(fip.Files[key], FileIndexLocation{Path: f.Name, Marks: []int{markIdx}})
+			}
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return fip, nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/shabbyrobe/prj"
+)
+
+const indexVersion = 2
+
+// Index is a snapshot of the projects found under the configured IndexPaths,
+// so they can be searched without walking the filesystem again.
+type Index struct {
+	Version int
+	Built   time.Time
+	Nested  bool
+	Entries []*IndexEntry
+
+	// Used by incremental builds to skip directories that haven't changed.
+	Dirs *prj.ScanDirCache `json:",omitempty"`
+}
+
+type IndexEntry struct {
+	ID      string
+	Kind    string
+	Name    string
+	Path    string
+	Hash    prj.Hash
+	ModTime time.Time
+	Tags    []string `json:",omitempty"`
+
+	// Hashes of the non-empty files recorded by the project's last entry, if
+	// the project kind supports it, using FilesAlgorithm. See indexFileHash.
+	FilesAlgorithm prj.HashAlgorithm `json:",omitempty"`
+	Files          []string          `json:",omitempty"`
+
+	// Modification time and size of the simple project's config file when
+	// the entry was built. Incremental builds only load the project again if
+	// these change. Zero if the project is not a simple project, or the file
+	// was modified too recently to be trusted.
+	ConfigModTime time.Time `json:",omitempty"`
+	ConfigSize    int64     `json:",omitempty"`
+}
+
+// IndexChanges counts the differences between two builds of the index.
+type IndexChanges struct {
+	Added   int
+	Updated int
+	Moved   int
+	Removed int
+}
+
+func (app *App) IndexFile() string {
+	return filepath.Join(app.cachePath, "index.json")
+}
+
+func loadIndex(file string) (*Index, error) {
+	bts, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("index %q not found, use 'prj index build' to create it", file)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var idx Index
+	if err := json.Unmarshal(bts, &idx); err != nil {
+		return nil, fmt.Errorf("index %q could not be read: %w", file, err)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("index %q has version %d, expected %d; use 'prj index build' to recreate it", file, idx.Version, indexVersion)
+	}
+	return &idx, nil
+}
+
+func (idx *Index) Save(file string) error {
+	sort.Slice(idx.Entries, func(i, j int) bool {
+		return idx.Entries[i].Path < idx.Entries[j].Path
+	})
+	return writeJSONFile(file, idx)
+}
+
+func writeJSONFile(file string, v interface{}) error {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	tmpFile := file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, bts, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+const indexConfigRacyWindow = 2 * time.Second
+
+// lastEntryStatuser is implemented by projects that can list the files
+// recorded by their last entry.
+type lastEntryStatuser interface {
+	LastEntryStatus(ctx context.Context) (*prj.ProjectStatus, error)
+}
+
+// indexFileHashSize is the number of bytes of each file hash kept in the
+// index. It's plenty to tell files apart, and keeps the index small.
+const indexFileHashSize = 12
+
+func indexFileHash(hash prj.Hash) string {
+	v := hash.Value
+	if len(v) > indexFileHashSize {
+		v = v[:indexFileHashSize]
+	}
+	return base64.RawURLEncoding.EncodeToString(v)
+}
+
+func newIndexEntry(ctx context.Context, path string, project prj.Project, at time.Time) (*IndexEntry, error) {
+	entry := &IndexEntry{
+		ID:   project.ID(),
+		Kind: project.Kind().String(),
+		Name: project.Name(),
+		Path: path,
+	}
+
+	if project.Kind() == prj.ProjectSimple {
+		// Stat before loading anything else, so a change made while the
+		// entry is being built is picked up next time:
+		info, err := os.Stat(indexConfigFile(path))
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Before(at.Add(-indexConfigRacyWindow)) {
+			entry.ConfigModTime, entry.ConfigSize = info.ModTime(), info.Size()
+		}
+	}
+
+	last, err := project.LastEntry()
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		entry.Hash = last.Hash
+		entry.ModTime = last.ModTime
+	}
+
+	if err := entry.refreshTags(project.Tagger()); err != nil {
+		return nil, err
+	}
+
+	if statuser, ok := project.(lastEntryStatuser); ok {
+		status, err := statuser.LastEntryStatus(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if status != nil {
+			seen := make(map[string]bool, len(status.Files))
+			for _, f := range status.Files {
+				if f.Size == 0 || f.Hash.IsEmpty() {
+					continue // All empty files have the same hash
+				}
+
+				// The file hashes don't necessarily use the same algorithm as
+				// the status, i.e. git blobs:
+				if entry.FilesAlgorithm == "" {
+					entry.FilesAlgorithm = f.Hash.Algorithm
+				} else if entry.FilesAlgorithm != f.Hash.Algorithm {
+					continue
+				}
+
+				if h := indexFileHash(f.Hash); !seen[h] {
+					seen[h] = true
+					entry.Files = append(entry.Files, h)
+				}
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+func indexConfigFile(path string) string {
+	return filepath.Join(path, prj.ProjectPath, prj.ProjectConfigFile)
+}
+
+func (entry *IndexEntry) refreshTags(tagger prj.Tagger) error {
+	tags, err := tagger.Tags()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	entry.Tags = tags
+	return nil
+}
+
+// reuse returns a copy of the entry if the project at 'found' can't have
+// changed since the entry was built, or nil if it must be loaded again.
+// Only simple projects can be reused; there is no single file that changes
+// whenever a version control repo does. Tags are cheap to read, and '.prjtags' can
+// be edited in place without changing anything else, so they are re-read.
+func (entry *IndexEntry) reuse(found *prj.FoundProject) (*IndexEntry, error) {
+	if found.Kind != prj.ProjectSimple || entry.Kind != found.Kind.String() || entry.ConfigModTime.IsZero() {
+		return nil, nil
+	}
+
+	info, err := os.Stat(indexConfigFile(found.Path))
+	if err != nil || !info.ModTime().Equal(entry.ConfigModTime) || info.Size() != entry.ConfigSize {
+		return nil, nil
+	}
+
+	reused := *entry
+	if err := reused.refreshTags(prj.TaggerForDir(found.Path)); err != nil {
+		return nil, err
+	}
+	return &reused, nil
+}
+
+func (entry *IndexEntry) equal(other *IndexEntry) bool {
+	if entry.ID != other.ID ||
+		entry.Kind != other.Kind ||
+		entry.Name != other.Name ||
+		entry.Hash.String() != other.Hash.String() ||
+		!entry.ModTime.Equal(other.ModTime) ||
+		len(entry.Tags) != len(other.Tags) {
+		return false
+	}
+	for i := range entry.Tags {
+		if entry.Tags[i] != other.Tags[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// indexScanRoots splits the configured IndexPaths into the paths to scan, and
+// scan exclusion patterns for the paths that are not included.
+func indexScanRoots(config Config) (roots []string, exclude []string, err error) {
+	for _, ip := range config.IndexPaths {
+		path, err := ip.Expand()
+		if err != nil {
+			return nil, nil, err
+		}
+		if ip.Included {
+			roots = append(roots, path)
+		} else {
+			exclude = append(exclude, "^"+regexp.QuoteMeta(filepath.ToSlash(path))+"(/|$)")
+		}
+	}
+	return roots, exclude, nil
+}
+
+// buildIndex scans every included IndexPath. Projects that could not be
+// loaded are returned in 'failed' rather than aborting the build.
+//
+// If 'prev' is not nil, the build is incremental: directories that haven't
+// changed since 'prev' was built are not read again, and simple projects
+// whose config hasn't changed are not loaded again.
+func buildIndex(ctx context.Context, config Config, prev *Index, nested bool, at time.Time) (idx *Index, failed []*prj.FoundProject, err error) {
+	roots, exclude, err := indexScanRoots(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(roots) == 0 {
+		return nil, nil, fmt.Errorf("no IndexPaths are included in the config file")
+	}
+
+	idx = &Index{Version: indexVersion, Built: at, Nested: nested, Dirs: prj.NewScanDirCache()}
+
+	prevEntries := map[string]*IndexEntry{}
+	if prev != nil && prev.Nested == nested {
+		if prev.Dirs != nil {
+			idx.Dirs = prev.Dirs
+		}
+		for _, entry := range prev.Entries {
+			prevEntries[entry.Path] = entry
+		}
+	}
+
+	priority, err := config.Priority()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := []prj.ScanOption{
+		prj.ScanDetectOnly(),
+		prj.ScanWithPriority(priority),
+		prj.ScanWithDirCache(idx.Dirs),
+		prj.ScanExcludePattern(append(exclude, defaultScanExcludes()...)...),
+	}
+	if nested {
+		opts = append(opts, prj.ScanNested())
+	}
+
+	seen := map[string]bool{}
+
+	for _, root := range roots {
+		scn := prj.Scan(root, opts...)
+		for scn.Next() {
+			if err := ctx.Err(); err != nil {
+				scn.Close()
+				return nil, nil, err
+			}
+
+			found := scn.Current()
+			if seen[found.Path] { // IndexPaths may overlap
+				continue
+			}
+			seen[found.Path] = true
+
+			entry, err := indexFoundProject(ctx, found, prevEntries[found.Path], at)
+			if err != nil {
+				failed = append(failed, &prj.FoundProject{Path: found.Path, Kind: found.Kind, Err: err})
+				continue
+			} else if entry != nil {
+				idx.Entries = append(idx.Entries, entry)
+			}
+		}
+		if err := scn.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	idx.Dirs.Prune()
+
+	return idx, failed, nil
+}
+
+func indexFoundProject(ctx context.Context, found *prj.FoundProject, prev *IndexEntry, at time.Time) (*IndexEntry, error) {
+	if prev != nil {
+		if entry, err := prev.reuse(found); err != nil || entry != nil {
+			return entry, err
+		}
+	}
+
+	project, err := found.Kind.Load(found.Path)
+	if err != nil {
+		return nil, err
+	}
+	return newIndexEntry(ctx, found.Path, project, at)
+}
+
+// Changes compares the index to a previous build. A project is considered to
+// have moved if an entry with the same ID and kind disappeared from one path
+// and appeared at another.
+func (idx *Index) Changes(prev *Index) (changes IndexChanges) {
+	prevEntries := map[string]*IndexEntry{}
+	if prev != nil {
+		for _, entry := range prev.Entries {
+			prevEntries[entry.Path] = entry
+		}
+	}
+
+	var added []*IndexEntry
+	for _, entry := range idx.Entries {
+		if old, ok := prevEntries[entry.Path]; ok {
+			delete(prevEntries, entry.Path)
+			if !entry.equal(old) {
+				changes.Updated++
+			}
+		} else {
+			added = append(added, entry)
+		}
+	}
+
+	// Whatever is left in prevEntries is gone from its old path:
+	gone := map[string][]*IndexEntry{}
+	for _, entry := range prevEntries {
+		if entry.ID != "" {
+			key := entry.Kind + ":" + entry.ID
+			gone[key] = append(gone[key], entry)
+		}
+	}
+	for _, entry := range added {
+		key := entry.Kind + ":" + entry.ID
+		if candidates := gone[key]; entry.ID != "" && len(candidates) > 0 {
+			changes.Moved++
+			gone[key] = candidates[1:]
+			delete(prevEntries, candidates[0].Path)
+		} else {
+			changes.Added++
+		}
+	}
+	changes.Removed = len(prevEntries)
+
+	return changes
+}
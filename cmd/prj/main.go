@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/shabbyrobe/cmdy"
 	"github.com/shabbyrobe/cmdy/cmdyutil"
+	prj "github.com/shabbyrobe/prj"
 )
 
 func main() {
@@ -20,9 +22,15 @@ func main() {
 type App struct {
 	config             Config
 	configFileOverride string
+	rootPriority       string
+	rootMode           string
 	wd                 string
 	configPath         string
 	cachePath          string
+
+	// Which project is used when a directory is in more than one; from the
+	// config file, overridden by the -priority and -root-mode flags.
+	priority prj.RootPriority
 }
 
 func (app App) ConfigFile() string {
@@ -51,7 +59,8 @@ func run() error {
 			return cmdy.NewGroup(
 				"Tools to build and search an index of found projects",
 				cmdy.Builders{
-					"build": func() cmdy.Command { return &indexBuildCommand{app: &app} },
+					"build":  func() cmdy.Command { return &indexBuildCommand{app: &app} },
+					"search": func() cmdy.Command { return &indexSearchCommand{app: &app} },
 				},
 			)
 		}
@@ -60,23 +69,36 @@ func run() error {
 			"prj: your friendly arbitrary project folder helper",
 
 			cmdy.Builders{
-				"diff":  func() cmdy.Command { return &diffCommand{} },
-				"find":  func() cmdy.Command { return &findCommand{} },
-				"hash":  func() cmdy.Command { return &hashCommand{} },
-				"list":  func() cmdy.Command { return &listCommand{} },
-				"init":  func() cmdy.Command { return &initCommand{} },
-				"id":    func() cmdy.Command { return &idCommand{} },
-				"index": indexGroup,
-				"info":  func() cmdy.Command { return &infoCommand{} },
-				"log":   func() cmdy.Command { return &logCommand{} },
-				"mark":  func() cmdy.Command { return &markCommand{} },
-				"tag":   func() cmdy.Command { return &tagCommand{} },
+				"compare":     func() cmdy.Command { return &compareCommand{} },
+				"diff":        func() cmdy.Command { return &diffCommand{app: &app} },
+				"dupes":       func() cmdy.Command { return &dupesCommand{app: &app} },
+				"find":        func() cmdy.Command { return &findCommand{app: &app} },
+				"fsck":        func() cmdy.Command { return &fsckCommand{} },
+				"gc":          func() cmdy.Command { return &gcCommand{} },
+				"hash":        func() cmdy.Command { return &hashCommand{app: &app} },
+				"list":        func() cmdy.Command { return &listCommand{} },
+				"init":        func() cmdy.Command { return &initCommand{} },
+				"id":          func() cmdy.Command { return &idCommand{app: &app} },
+				"index":       indexGroup,
+				"info":        func() cmdy.Command { return &infoCommand{app: &app} },
+				"log":         func() cmdy.Command { return &logCommand{app: &app} },
+				"mark":        func() cmdy.Command { return &markCommand{} },
+				"objects":     func() cmdy.Command { return &objectsCommand{} },
+				"rehash":      func() cmdy.Command { return &rehashCommand{} },
+				"restore":     func() cmdy.Command { return &restoreCommand{} },
+				"similar":     func() cmdy.Command { return &similarCommand{app: &app} },
+				"subprojects": func() cmdy.Command { return &subProjectsCommand{} },
+				"tag":         func() cmdy.Command { return &tagCommand{} },
+				"verify":      func() cmdy.Command { return &verifyCommand{app: &app} },
+				"which-file":  func() cmdy.Command { return &whichFileCommand{app: &app} },
 			},
 
 			cmdy.GroupFlags(func() *cmdy.FlagSet {
 				flags := cmdy.NewFlagSet()
 				flags.StringVar(&app.wd, "C", "", "Run subcommand inside this working directory (instead of cwd)")
 				flags.StringVar(&app.configFileOverride, "config", "", "Use this config file instead of the one in your user config dir")
+				flags.StringVar(&app.rootPriority, "priority", "", "Comma separated kinds of project to look for, highest priority first (overrides RootPriority in the config file)")
+				flags.StringVar(&app.rootMode, "root-mode", "", "'priority' to look for each kind in all parent directories before the next kind, or 'nearest' to use the nearest project (overrides RootMode in the config file)")
 				return flags
 			}),
 
@@ -93,6 +115,30 @@ func run() error {
 						return err
 					}
 				}
+				// The flags replace the config file's settings before anything
+				// is validated, so a bad setting the flags replace doesn't
+				// stop the command:
+				if app.rootPriority != "" {
+					app.config.RootPriority = nil
+					for _, kind := range strings.Split(app.rootPriority, ",") {
+						app.config.RootPriority = append(app.config.RootPriority, strings.TrimSpace(kind))
+					}
+				}
+				if app.rootMode != "" {
+					app.config.RootMode = app.rootMode
+				}
+				if err := app.config.Validate(); err != nil {
+					if app.rootPriority != "" || app.rootMode != "" {
+						return cmdy.UsageErrorf("config file %q with -priority/-root-mode invalid: %v", configFile, err)
+					}
+					return fmt.Errorf("config file %q invalid: %w", configFile, err)
+				}
+
+				priority, err := app.config.Priority()
+				if err != nil {
+					return err
+				}
+				app.priority = priority
 
 				return nil
 			}),
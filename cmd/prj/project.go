@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"time"
@@ -11,7 +10,7 @@ import (
 	prj "github.com/shabbyrobe/prj"
 )
 
-func loadProject(searchPath string, priority []prj.ProjectKind) (prj.Project, *prj.Session, error) {
+func loadProject(searchPath string, priority prj.RootPriority) (prj.Project, *prj.Session, error) {
 	if searchPath == "" {
 		wd, err := os.Getwd()
 		if err != nil {
@@ -20,7 +19,7 @@ func loadProject(searchPath string, priority []prj.ProjectKind) (prj.Project, *p
 		searchPath = wd
 	}
 
-	project, err := prj.Load(searchPath, priority)
+	project, err := prj.LoadWithPriority(searchPath, priority)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -33,20 +32,7 @@ func loadProject(searchPath string, priority []prj.ProjectKind) (prj.Project, *p
 	return project, session, nil
 }
 
-type errProjectNotFound struct {
-	Path  string
-	Kinds []prj.ProjectKind
-}
-
-func (err *errProjectNotFound) Is(target error) bool {
-	return target == prj.ErrProjectNotFound
-}
-
-func (err *errProjectNotFound) Error() string {
-	return fmt.Sprintf("prj: project not found in %q or any of its parents", err.Path)
-}
-
-func loadSimpleProject(searchPath string) (prj.Project, *prj.Session, error) {
+func loadSimpleProject(searchPath string) (*prj.SimpleProject, *prj.Session, error) {
 	if searchPath == "" {
 		wd, err := os.Getwd()
 		if err != nil {
@@ -73,7 +59,7 @@ func loadSimpleProject(searchPath string) (prj.Project, *prj.Session, error) {
 	return project, session, nil
 }
 
-func loadSimpleProjectWithTemporaryFallback(ctx context.Context, searchPath string, fallbackPath string) (p prj.Project, sess *prj.Session, done func(), err error) {
+func loadProjectWithTemporaryFallback(ctx context.Context, searchPath string, fallbackPath string, priority prj.RootPriority) (p prj.Project, sess *prj.Session, done func(), err error) {
 	done = func() {}
 	defer func() {
 		if err != nil {
@@ -82,7 +68,7 @@ func loadSimpleProjectWithTemporaryFallback(ctx context.Context, searchPath stri
 		}
 	}()
 
-	p, sess, err = loadSimpleProject(searchPath)
+	p, sess, err = loadProject(searchPath, priority)
 	if errors.Is(err, prj.ErrProjectNotFound) {
 		if fallbackPath == "" {
 			return p, sess, done, err
@@ -94,7 +80,7 @@ func loadSimpleProjectWithTemporaryFallback(ctx context.Context, searchPath stri
 	return p, sess, done, err
 }
 
-func loadTemporaryProject(ctx context.Context, path string) (p prj.Project, sess *prj.Session, done func(), err error) {
+func loadTemporaryProject(ctx context.Context, path string, options ...prj.InitOption) (p prj.Project, sess *prj.Session, done func(), err error) {
 	done = func() {}
 
 	sess, err = prj.NewOSSession()
@@ -117,9 +103,13 @@ func loadTemporaryProject(ctx context.Context, path string) (p prj.Project, sess
 		}
 	}()
 
-	p, _, err = prj.InitSimpleProject(
-		ctx, sess, path, path, time.Now(),
-		prj.InitWithSeparateMetaPath(metaPath))
+	options = append(options, prj.InitWithSeparateMetaPath(metaPath))
+	p, _, err = prj.InitSimpleProject(ctx, sess, path, path, time.Now(), options...)
 
 	return p, sess, done, err
 }
+
+// worktreeRef refers to the current state of a project, rather than a mark.
+const worktreeRef = "worktree"
+
+const markRefHelp = "Marks can be a hash prefix, '~N' for the Nth mark before the last (quote it to stop the shell expanding it), or a date (i.e. '2020-06-01')."
@@ -23,7 +23,7 @@ func bytesHuman(b int64, precision int) string {
 	case b >= sizeKiB:
 		v, suffix = v/sizeKiB, "KiB"
 	default:
-		v, suffix = v, "B"
+		suffix = "B"
 	}
 	return fmt.Sprintf("%.*f %s", precision, v, suffix)
 }
@@ -21,9 +21,37 @@ type SimpleProjectConfig struct {
 	Name     string
 	InitDate time.Time
 
+	// Algorithm used to hash files in the project. Projects created before
+	// this was configurable will not have it set, in which case they use
+	// HashSHA512.
+	HashAlgorithm HashAlgorithm `json:",omitempty"`
+
+	// How to treat projects nested inside this one. SubProjectInclude if
+	// empty.
+	SubProjects SubProjectPolicy `json:",omitempty"`
+
+	// Where to keep the content of marked files, so marks can be restored:
+	// ObjectStoreLocal, or the absolute path to a store shared between
+	// projects. Disabled if empty.
+	ObjectStore string `json:",omitempty"`
+
 	LastEntry *LogEntry
 }
 
+func (c *SimpleProjectConfig) subProjectPolicy() SubProjectPolicy {
+	if c.SubProjects == "" {
+		return SubProjectInclude
+	}
+	return c.SubProjects
+}
+
+func (c *SimpleProjectConfig) hashAlgorithm() HashAlgorithm {
+	if c.HashAlgorithm == HashNone {
+		return HashSHA512
+	}
+	return c.HashAlgorithm
+}
+
 func FindSimpleProjectRoot(in string) (path string, err error) {
 	if !filepath.IsAbs(in) {
 		return "", fmt.Errorf("prj: input %q is not absolute", in)
@@ -18,6 +18,7 @@ func (ec ErrorCode) Error() string {
 
 const (
 	ErrProjectNotFound ErrorCode = iota + 1
+	ErrProjectLocked
 )
 
 type errProjectNotFound struct {
@@ -32,3 +33,15 @@ func (err *errProjectNotFound) Is(target error) bool {
 func (err *errProjectNotFound) Error() string {
 	return fmt.Sprintf("prj: project not found in %q or any of its parents", err.Path)
 }
+
+type errProjectLocked struct {
+	Path string
+}
+
+func (err *errProjectLocked) Is(target error) bool {
+	return target == ErrProjectLocked
+}
+
+func (err *errProjectLocked) Error() string {
+	return fmt.Sprintf("prj: project %q is locked by another process", err.Path)
+}
@@ -0,0 +1,110 @@
+package prj
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+// foreignProject implements the parts of Project that are the same for the
+// version control systems prj can identify, but can't read the status or
+// history of.
+type foreignProject struct {
+	path string
+	id   string
+	kind ProjectKind
+}
+
+func (p *foreignProject) ID() string { return p.id }
+
+func (p *foreignProject) Name() string {
+	_, name := filepath.Split(strings.TrimRight(p.path, string(filepath.Separator)))
+	return name
+}
+
+func (p *foreignProject) Path() string      { return p.path }
+func (p *foreignProject) Kind() ProjectKind { return p.kind }
+
+// LastEntry always returns nil, as reading the history isn't supported.
+func (p *foreignProject) LastEntry() (*LogEntry, error) {
+	return nil, nil
+}
+
+func (p *foreignProject) Status(ctx context.Context, path ResourcePath, at time.Time, options *StatusOptions) (*ProjectStatus, error) {
+	return nil, fmt.Errorf("prj: not implemented for %s projects", p.kind)
+}
+
+func (p *foreignProject) Diff(ctx context.Context, path ResourcePath, at time.Time, options *StatusOptions) (*ProjectDiff, error) {
+	return nil, fmt.Errorf("prj: not implemented for %s projects", p.kind)
+}
+
+func (p *foreignProject) Mark(ctx context.Context, session *Session, message string, at time.Time, options *MarkOptions) (*ProjectStatus, error) {
+	return nil, fmt.Errorf("prj: not implemented for %s projects", p.kind)
+}
+
+func (p *foreignProject) Log() LogIterator {
+	return &nilLogIterator{}
+}
+
+func (p *foreignProject) Tagger() Tagger {
+	return fileTaggerFromDir(p.path)
+}
+
+// findInFile looks for 're' in the raw bytes of 'file', without reading the
+// whole thing into memory. This is good enough to dig a value with a
+// distinctive format out of a text file whose format is otherwise not worth
+// parsing. Matches that 'accept' rejects are skipped; matches longer than
+// 'maxLen' may be missed.
+func findInFile(file string, re *regexp.Regexp, maxLen int, accept func(match [][]byte) bool) (match [][]byte, rerr error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	const chunkSize = 1 << 20
+
+	buf := make([]byte, 0, chunkSize+maxLen)
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := f.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		eof := err == io.EOF
+		if err != nil && !eof {
+			return nil, err
+		}
+
+		// Matches that reach the end of the buffer may continue into the
+		// next chunk, so they are left for the next pass:
+		for _, idx := range re.FindAllSubmatchIndex(buf, -1) {
+			if !eof && idx[1] >= len(buf) {
+				break
+			}
+			match := make([][]byte, len(idx)/2)
+			for i := range match {
+				if idx[i*2] >= 0 {
+					match[i] = append([]byte(nil), buf[idx[i*2]:idx[i*2+1]]...)
+				}
+			}
+			if accept == nil || accept(match) {
+				return match, nil
+			}
+		}
+
+		if eof {
+			return nil, nil
+		}
+
+		// Keep the tail, in case a match straddles the chunk boundary:
+		if len(buf) > maxLen {
+			buf = append(buf[:0], buf[len(buf)-maxLen:]...)
+		}
+	}
+}
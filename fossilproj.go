@@ -0,0 +1,97 @@
+package prj
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+type FossilProject struct {
+	foreignProject
+}
+
+var _ Project = &FossilProject{}
+
+// Fossil checkouts have a database in the root; it has a different name on
+// Windows.
+var fossilCheckoutFiles = []string{".fslckout", "_FOSSIL_"}
+
+func ContainsFossilProject(dir string) (ok bool, err error) {
+	if !filepath.IsAbs(dir) {
+		return false, fmt.Errorf("prj: input %q is not absolute", dir)
+	}
+	return containsFossilProjectUnchecked(dir)
+}
+
+func containsFossilProjectUnchecked(dir string) (ok bool, err error) {
+	_, ok, err = fossilCheckoutFile(dir)
+	return ok, err
+}
+
+func fossilCheckoutFile(dir string) (file string, ok bool, err error) {
+	for _, name := range fossilCheckoutFiles {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			return file, true, nil
+		} else if !os.IsNotExist(err) {
+			return "", false, err
+		}
+	}
+	return "", false, nil
+}
+
+func LoadFossilProject(path string) (*FossilProject, error) {
+	id, err := fossilReadID(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FossilProject{foreignProject{path: path, id: id, kind: ProjectFossil}}, nil
+}
+
+// fossilReadID uses the project code, which is shared by every clone of the
+// repository the checkout was opened from.
+func fossilReadID(path string) (string, error) {
+	checkout, ok, err := fossilCheckoutFile(path)
+	if err != nil {
+		return "", err
+	} else if !ok {
+		return "", fmt.Errorf("prj: fossil checkout not found in %q", path)
+	}
+
+	// The checkout's 'vvar' table has the path to the repository:
+	repo, err := fossilReadSetting(checkout, "vvar", "repository")
+	if err != nil {
+		return "", err
+	} else if repo == "" {
+		return "", fmt.Errorf("prj: fossil repository for checkout %q not found", path)
+	}
+	if !filepath.IsAbs(repo) {
+		repo = filepath.Join(path, repo)
+	}
+
+	// Clones of a repository created with 'fossil new --derive' also have a
+	// 'parent-project-code', which is not the one we want:
+	code, err := fossilReadSetting(repo, "config", "project-code")
+	if err != nil {
+		return "", err
+	} else if !fossilProjectCodePattern.MatchString(code) {
+		return "", fmt.Errorf("prj: project code not found in fossil repository %q", repo)
+	}
+	return code, nil
+}
+
+var fossilProjectCodePattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// fossilReadSetting reads the value for 'name' from one of fossil's
+// name/value tables, which all start with (name, value).
+func fossilReadSetting(db string, table string, name string) (value string, err error) {
+	err = readSqliteFile(db, table, func(row sqliteRow) bool {
+		if rowName, _ := row.Text(0); rowName != name {
+			return true
+		}
+		value, _ = row.Text(1)
+		return false
+	})
+	return value, err
+}
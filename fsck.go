@@ -0,0 +1,367 @@
+package prj
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+const projectOrphansPath = "orphans" // Child of ProjectPath
+
+type FsckProblemKind string
+
+const (
+	// The last line of the log was not completely written.
+	FsckPartialLogLine FsckProblemKind = "partial-log-line"
+
+	// A line in the log could not be parsed.
+	FsckBadLogLine FsckProblemKind = "bad-log-line"
+
+	// A log entry refers to a status file that doesn't exist. If it's the
+	// last entry, a mark was probably interrupted before it was written.
+	FsckMissingStatus FsckProblemKind = "missing-status"
+
+	// A status file could not be read.
+	FsckBadStatus FsckProblemKind = "bad-status"
+
+	// The hash of the files in a status file does not match the hash in its
+	// log entry.
+	FsckHashMismatch FsckProblemKind = "hash-mismatch"
+
+	// The config's LastEntry is not the last entry in the log.
+	FsckStaleConfig FsckProblemKind = "stale-config"
+
+	// A file in '.prj/status' is not referred to by any log entry, i.e. a
+	// mark was interrupted before the log was written.
+	FsckOrphanStatus FsckProblemKind = "orphan-status"
+)
+
+type FsckProblem struct {
+	Kind    FsckProblemKind
+	Message string
+
+	// True if FsckOptions.Repair can fix this problem. Problems that would
+	// need data that no longer exists, like a damaged status file, can only
+	// be reported.
+	Repairable bool
+
+	// True if the problem was repaired.
+	Repaired bool
+}
+
+func (p FsckProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Kind, p.Message)
+}
+
+type FsckOptions struct {
+	// Fix the problems that can be fixed. Log lines that can't be parsed and
+	// trailing entries without a status file are removed from the log, the
+	// config is rebuilt from the log, and orphaned status files are moved to
+	// '.prj/orphans'. Removed log lines are kept in '.prj/orphans/log.jsonl'.
+	Repair bool
+}
+
+type FsckResult struct {
+	// Number of log entries that were checked.
+	Entries int
+
+	Problems []FsckProblem
+}
+
+// Unrepaired counts the problems that have not been repaired.
+func (r *FsckResult) Unrepaired() (n int) {
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *FsckResult) add(kind FsckProblemKind, msg string, args ...interface{}) {
+	r.Problems = append(r.Problems, FsckProblem{Kind: kind, Message: fmt.Sprintf(msg, args...)})
+}
+
+func (r *FsckResult) addRepairable(kind FsckProblemKind, msg string, args ...interface{}) {
+	r.Problems = append(r.Problems, FsckProblem{Kind: kind, Message: fmt.Sprintf(msg, args...), Repairable: true})
+}
+
+func (r *FsckResult) repaired() {
+	for i := range r.Problems {
+		if r.Problems[i].Repairable {
+			r.Problems[i].Repaired = true
+		}
+	}
+}
+
+type fsckLogLine struct {
+	raw   []byte
+	entry *LogEntry
+}
+
+// Fsck checks the project's metadata: that every line in the log parses,
+// that every status file the log refers to exists and matches its entry's
+// hash, that the config's LastEntry is the last entry in the log, and that
+// there are no status files the log doesn't refer to.
+//
+// Log entries without a status file are not a problem; early versions of
+// prj didn't write them.
+func (s *SimpleProject) Fsck(ctx context.Context, options *FsckOptions) (rresult *FsckResult, rerr error) {
+	if options == nil {
+		options = &FsckOptions{}
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer errtools.DeferClose(&rerr, closerFunc(unlock))
+
+	if err := s.refreshConfig(); err != nil {
+		return nil, err
+	}
+
+	var result FsckResult
+
+	lines, rejected, logChanged, err := s.fsckLog(&result)
+	if err != nil {
+		return nil, err
+	}
+	result.Entries = len(lines)
+
+	if err := s.fsckStatusFiles(ctx, &result, lines); err != nil {
+		return nil, err
+	}
+
+	var last *LogEntry
+	if len(lines) > 0 {
+		last = lines[len(lines)-1].entry
+	}
+	configChanged := false
+	if same, err := sameLogEntry(s.config.LastEntry, last); err != nil {
+		return nil, err
+	} else if !same {
+		result.addRepairable(FsckStaleConfig, "config's last entry does not match the log")
+		configChanged = true
+	}
+
+	orphans, err := s.fsckOrphans(ctx, &result, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	if !options.Repair || len(result.Problems) == 0 {
+		return &result, nil
+	}
+
+	orphanPath := filepath.Join(s.metaRoot, ProjectPath, projectOrphansPath)
+	if len(orphans) > 0 || len(rejected) > 0 {
+		if err := os.MkdirAll(orphanPath, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rejected) > 0 {
+		// Nothing is thrown away, in case the lines can be fixed by hand:
+		var buf bytes.Buffer
+		for _, raw := range rejected {
+			buf.Write(raw)
+			buf.WriteByte('\n')
+		}
+		if err := appendFileSync(filepath.Join(orphanPath, ProjectLogFile), buf.Bytes(), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	if logChanged {
+		var buf bytes.Buffer
+		for _, line := range lines {
+			buf.Write(line.raw)
+			buf.WriteByte('\n')
+		}
+		if err := writeFileAtomic(s.logFile(), buf.Bytes(), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	if configChanged {
+		s.config.LastEntry = last
+		if err := s.saveConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range orphans {
+		if err := os.Rename(filepath.Join(s.statusPath(), file), filepath.Join(orphanPath, file)); err != nil {
+			return nil, err
+		}
+	}
+
+	result.repaired()
+	return &result, nil
+}
+
+// fsckLog reads the log, and returns the lines that should be kept and the
+// raw lines that should be removed. Lines that can't be parsed, and trailing
+// entries whose status file was never written, are removed; if any were,
+// 'changed' is true.
+func (s *SimpleProject) fsckLog(result *FsckResult) (lines []fsckLogLine, rejected [][]byte, changed bool, err error) {
+	bts, err := ioutil.ReadFile(s.logFile())
+	if os.IsNotExist(err) {
+		return nil, nil, false, nil
+	} else if err != nil {
+		return nil, nil, false, err
+	}
+
+	raw := bytes.Split(bts, []byte{'\n'})
+
+	// A complete log always ends with a newline, so the final element is
+	// either empty or a partial line:
+	if tail := raw[len(raw)-1]; len(bytes.TrimSpace(tail)) > 0 {
+		var entry LogEntry
+		if err := json.Unmarshal(tail, &entry); err == nil {
+			// Only the newline is missing, so the line is worth keeping.
+			result.addRepairable(FsckPartialLogLine, "last log entry is missing its newline")
+		} else {
+			result.addRepairable(FsckPartialLogLine, "last log line is incomplete: %q", truncateForMessage(tail))
+			rejected = append(rejected, tail)
+			raw = raw[:len(raw)-1]
+		}
+		changed = true
+	}
+
+	for idx, line := range raw {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			result.addRepairable(FsckBadLogLine, "log line %d could not be parsed: %v", idx+1, err)
+			rejected = append(rejected, line)
+			changed = true
+			continue
+		}
+		lines = append(lines, fsckLogLine{raw: line, entry: &entry})
+	}
+
+	for len(lines) > 0 {
+		entry := lines[len(lines)-1].entry
+		if entry.StatusFile == "" {
+			break
+		}
+		if _, err := os.Stat(filepath.Join(s.statusPath(), entry.StatusFile)); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return nil, nil, false, err
+		}
+		result.addRepairable(FsckMissingStatus, "last log entry from %s has no status file %q", entry.Time, entry.StatusFile)
+		rejected = append(rejected, lines[len(lines)-1].raw)
+		lines = lines[:len(lines)-1]
+		changed = true
+	}
+
+	return lines, rejected, changed, nil
+}
+
+// fsckStatusFiles checks that the status file for every entry exists, and
+// that the hash of its files matches the entry. The trailing entries that
+// fsckLog removes have already been reported, so they aren't passed in.
+func (s *SimpleProject) fsckStatusFiles(ctx context.Context, result *FsckResult, lines []fsckLogLine) error {
+	for _, line := range lines {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry := line.entry
+		if entry.StatusFile == "" {
+			continue
+		}
+
+		status, err := s.readStatusFile(entry.StatusFile)
+		if os.IsNotExist(err) {
+			result.add(FsckMissingStatus, "log entry from %s has no status file %q", entry.Time, entry.StatusFile)
+			continue
+		} else if err != nil {
+			result.add(FsckBadStatus, "status file %q could not be read: %v", entry.StatusFile, err)
+			continue
+		}
+
+		if !entry.Hash.Algorithm.CanCreate() {
+			// Nothing to recompute the hash with; not something fsck can
+			// check.
+			continue
+		}
+
+		calculated := NewProjectStatusWithAlgorithm(status.Files, status.ModTime, entry.Hash.Algorithm)
+		if ok, err := calculated.Hash.Equal(entry.Hash); err != nil {
+			return err
+		} else if !ok {
+			result.add(FsckHashMismatch, "files in status file %q do not match the hash of the log entry from %s", entry.StatusFile, entry.Time)
+		}
+	}
+
+	return nil
+}
+
+func (s *SimpleProject) fsckOrphans(ctx context.Context, result *FsckResult, lines []fsckLogLine) (orphans []string, err error) {
+	files, err := ioutil.ReadDir(s.statusPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		referenced[line.entry.StatusFile] = true
+	}
+
+	for _, fi := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if fi.IsDir() || referenced[fi.Name()] {
+			continue
+		}
+		if strings.HasSuffix(fi.Name(), ".tmp") {
+			result.addRepairable(FsckOrphanStatus, "status file %q was not completely written", fi.Name())
+		} else {
+			result.addRepairable(FsckOrphanStatus, "status file %q is not in the log", fi.Name())
+		}
+		orphans = append(orphans, fi.Name())
+	}
+	sort.Strings(orphans)
+
+	return orphans, nil
+}
+
+func sameLogEntry(a, b *LogEntry) (bool, error) {
+	if a == nil || b == nil {
+		return a == b, nil
+	}
+	abts, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	bbts, err := json.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(abts, bbts), nil
+}
+
+func truncateForMessage(bts []byte) string {
+	const max = 40
+	if len(bts) > max {
+		return string(bts[:max]) + "..."
+	}
+	return string(bts)
+}
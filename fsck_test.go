@@ -0,0 +1,283 @@
+package prj
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFsckTestProject creates a project with two marks.
+func newFsckTestProject(t *testing.T) *SimpleProject {
+	t.Helper()
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	proj := newTestProject(t, map[string]string{"foo": "foo", "bar": "bar"}, at)
+	writeTestFile(t, filepath.Join(proj.dataRoot, "foo"), "changed")
+	if _, err := proj.Mark(context.Background(), testSession, "second", at.Add(time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	return proj
+}
+
+func lastTestEntry(t *testing.T, proj *SimpleProject) *LogEntry {
+	t.Helper()
+	entries, err := proj.logEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries[len(entries)-1]
+}
+
+func fsckProblemKinds(result *FsckResult) (kinds []string) {
+	for _, p := range result.Problems {
+		kinds = append(kinds, string(p.Kind))
+	}
+	return kinds
+}
+
+func TestFsckRepair(t *testing.T) {
+	for idx, tc := range []struct {
+		name    string
+		setup   func(t *testing.T, proj *SimpleProject)
+		kinds   []FsckProblemKind
+		entries int
+
+		// Files expected in '.prj/orphans' after the repair:
+		orphans []string
+
+		// Number of lines expected in '.prj/orphans/log.jsonl':
+		orphanLines int
+	}{
+		{name: "clean", entries: 2},
+
+		{
+			name: "truncated-last-line",
+			setup: func(t *testing.T, proj *SimpleProject) {
+				bts, err := ioutil.ReadFile(proj.logFile())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(proj.logFile(), bts[:len(bts)-10], 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			// The config still refers to the lost entry, and nothing refers
+			// to its status file:
+			kinds:       []FsckProblemKind{FsckPartialLogLine, FsckStaleConfig, FsckOrphanStatus},
+			entries:     1,
+			orphans:     []string{"*" + statusFileCompactExt, ProjectLogFile},
+			orphanLines: 1,
+		},
+
+		{
+			name: "missing-newline",
+			setup: func(t *testing.T, proj *SimpleProject) {
+				bts, err := ioutil.ReadFile(proj.logFile())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(proj.logFile(), bts[:len(bts)-1], 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			kinds:   []FsckProblemKind{FsckPartialLogLine},
+			entries: 2,
+		},
+
+		{
+			name: "missing-last-status",
+			setup: func(t *testing.T, proj *SimpleProject) {
+				last := lastTestEntry(t, proj)
+				if err := os.Remove(filepath.Join(proj.statusPath(), last.StatusFile)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			kinds:       []FsckProblemKind{FsckMissingStatus, FsckStaleConfig},
+			entries:     1,
+			orphans:     []string{ProjectLogFile},
+			orphanLines: 1,
+		},
+
+		{
+			name: "orphan-tmp",
+			setup: func(t *testing.T, proj *SimpleProject) {
+				writeTestFile(t, filepath.Join(proj.statusPath(), "partial.json.gz.tmp"), "partial")
+			},
+			kinds:   []FsckProblemKind{FsckOrphanStatus},
+			entries: 2,
+			orphans: []string{"partial.json.gz.tmp"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			proj := newFsckTestProject(t)
+			if tc.setup != nil {
+				tc.setup(t, proj)
+			}
+
+			result, err := proj.Fsck(ctx, nil)
+			if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			kinds := strings.Join(fsckProblemKinds(result), ",")
+			if expected := joinFsckKinds(tc.kinds); kinds != expected {
+				t.Fatalf("%d: expected problems %q, found %q", idx, expected, kinds)
+			}
+			if result.Unrepaired() != len(tc.kinds) {
+				t.Fatalf("%d: expected nothing repaired without Repair", idx)
+			}
+
+			result, err = proj.Fsck(ctx, &FsckOptions{Repair: true})
+			if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if result.Unrepaired() != 0 {
+				t.Fatalf("%d: expected all problems repaired, found %v", idx, result.Problems)
+			}
+
+			entries, err := proj.logEntries()
+			if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if len(entries) != tc.entries {
+				t.Fatalf("%d: expected %d entries, found %d", idx, tc.entries, len(entries))
+			}
+			if err := proj.refreshConfig(); err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if same, err := sameLogEntry(proj.config.LastEntry, entries[len(entries)-1]); err != nil || !same {
+				t.Fatalf("%d: expected config's last entry to match the log: %v", idx, err)
+			}
+
+			orphanPath := filepath.Join(proj.metaRoot, ProjectPath, projectOrphansPath)
+			orphans, _ := ioutil.ReadDir(orphanPath)
+			if len(orphans) != len(tc.orphans) {
+				t.Fatalf("%d: expected orphans %v, found %d files", idx, tc.orphans, len(orphans))
+			}
+			for _, fi := range orphans {
+				if fi.Name() == ProjectLogFile {
+					bts, err := ioutil.ReadFile(filepath.Join(orphanPath, fi.Name()))
+					if err != nil {
+						t.Fatalf("%d: %v", idx, err)
+					}
+					if lines := strings.Count(string(bts), "\n"); lines != tc.orphanLines {
+						t.Fatalf("%d: expected %d orphaned log lines, found %d", idx, tc.orphanLines, lines)
+					}
+					continue
+				}
+				if !matchesAnyName(fi.Name(), tc.orphans) {
+					t.Fatalf("%d: unexpected orphan %q", idx, fi.Name())
+				}
+			}
+
+			// Every status the log refers to is still there:
+			for _, entry := range entries {
+				if _, err := proj.MarkStatus(entry); err != nil {
+					t.Fatalf("%d: %v", idx, err)
+				}
+			}
+
+			result, err = proj.Fsck(ctx, nil)
+			if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if len(result.Problems) != 0 {
+				t.Fatalf("%d: expected no problems after repair, found %v", idx, result.Problems)
+			}
+		})
+	}
+}
+
+func joinFsckKinds(kinds []FsckProblemKind) string {
+	strs := make([]string, len(kinds))
+	for i, kind := range kinds {
+		strs[i] = string(kind)
+	}
+	return strings.Join(strs, ",")
+}
+
+func matchesAnyName(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFsckValidate(t *testing.T) {
+	for idx, tc := range []struct {
+		name       string
+		setup      func(t *testing.T, proj *SimpleProject)
+		kinds      []FsckProblemKind
+		repairable bool
+	}{
+		{
+			name: "bad-log-line",
+			setup: func(t *testing.T, proj *SimpleProject) {
+				bts, err := ioutil.ReadFile(proj.logFile())
+				if err != nil {
+					t.Fatal(err)
+				}
+				bts = append([]byte("not json\n"), bts...)
+				if err := ioutil.WriteFile(proj.logFile(), bts, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			kinds:      []FsckProblemKind{FsckBadLogLine},
+			repairable: true,
+		},
+
+		{
+			name: "bad-status",
+			setup: func(t *testing.T, proj *SimpleProject) {
+				last := lastTestEntry(t, proj)
+				writeTestFile(t, filepath.Join(proj.statusPath(), last.StatusFile), "not a status")
+			},
+			kinds: []FsckProblemKind{FsckBadStatus},
+		},
+
+		{
+			name: "hash-mismatch",
+			setup: func(t *testing.T, proj *SimpleProject) {
+				entries, err := proj.logEntries()
+				if err != nil {
+					t.Fatal(err)
+				}
+				first, err := ioutil.ReadFile(filepath.Join(proj.statusPath(), entries[0].StatusFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeTestFile(t, filepath.Join(proj.statusPath(), entries[1].StatusFile), string(first))
+			},
+			kinds: []FsckProblemKind{FsckHashMismatch},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			proj := newFsckTestProject(t)
+			tc.setup(t, proj)
+
+			result, err := proj.Fsck(ctx, &FsckOptions{Repair: true})
+			if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			kinds := strings.Join(fsckProblemKinds(result), ",")
+			if expected := joinFsckKinds(tc.kinds); kinds != expected {
+				t.Fatalf("%d: expected problems %q, found %q", idx, expected, kinds)
+			}
+			if unrepaired := result.Unrepaired(); (unrepaired == 0) != tc.repairable {
+				t.Fatalf("%d: expected repairable=%v, found %d unrepaired", idx, tc.repairable, unrepaired)
+			}
+
+			// Nothing that was in the log is lost:
+			if entries, err := proj.logEntries(); err != nil || len(entries) != 2 {
+				t.Fatalf("%d: expected 2 entries, found %d: %v", idx, len(entries), err)
+			}
+		})
+	}
+}
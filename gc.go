@@ -0,0 +1,214 @@
+package prj
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+type GCOptions struct {
+	// Remove the status files of these marks. The log entries are kept, so
+	// the marks still show in the log, but they can no longer be diffed
+	// against or restored. Entries are matched by Time and StatusFile.
+	Drop []*LogEntry
+
+	// If greater than zero, remove the status files of all but the last Keep
+	// marks, as if they were passed in Drop.
+	Keep int
+}
+
+type GCResult struct {
+	// Number of status files converted to the compact format.
+	Converted int
+
+	// Number of marks whose status was dropped.
+	Dropped int
+
+	// Total size of the status files of the project's marks, before and
+	// after.
+	SizeBefore, SizeAfter int64
+}
+
+// GC converts status files to the compact format, and removes the status
+// files of the marks in GCOptions.Drop. The status of the last mark is never
+// dropped, as it's what the project is compared to.
+func (s *SimpleProject) GC(ctx context.Context, options *GCOptions) (rresult *GCResult, rerr error) {
+	if options == nil {
+		options = &GCOptions{}
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer errtools.DeferClose(&rerr, closerFunc(unlock))
+
+	if err := s.refreshConfig(); err != nil {
+		return nil, err
+	}
+
+	entries, err := s.logEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	drop, err := gcDropSet(entries, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var result GCResult
+
+	statusPath := s.statusPath()
+	obsolete := map[string]bool{}
+	converted := map[string]string{}
+	sized := map[string]bool{}
+
+	for i, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if entry.StatusFile == "" {
+			continue
+		}
+
+		if !sized[entry.StatusFile] {
+			sized[entry.StatusFile] = true
+			if fi, err := os.Stat(filepath.Join(statusPath, entry.StatusFile)); err == nil {
+				result.SizeBefore += fi.Size()
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+
+		if drop[i] {
+			obsolete[entry.StatusFile] = true
+			entry.StatusFile = ""
+			result.Dropped++
+			continue
+		}
+
+		if isCompactStatusFile(entry.StatusFile) {
+			continue
+		}
+
+		// Several marks can share a status file if nothing changed between
+		// them and the mark was forced:
+		if to, ok := converted[entry.StatusFile]; ok {
+			entry.StatusFile = to
+			continue
+		}
+
+		status, err := s.readStatusFile(entry.StatusFile)
+		if err != nil {
+			return nil, fmt.Errorf("prj: could not read status file for log entry at %s: %w", entry.Time, err)
+		}
+		statusData, err := encodeStatus(status)
+		if err != nil {
+			return nil, err
+		}
+
+		to := compactStatusFileName(entry.StatusFile)
+		if err := writeFileAtomic(filepath.Join(statusPath, to), statusData, 0600); err != nil {
+			return nil, err
+		}
+
+		converted[entry.StatusFile] = to
+		obsolete[entry.StatusFile] = true
+		entry.StatusFile = to
+		result.Converted++
+	}
+
+	if result.Converted == 0 && result.Dropped == 0 {
+		result.SizeAfter = result.SizeBefore
+		return &result, nil
+	}
+
+	{ // Replace the log
+		var buf bytes.Buffer
+		for _, entry := range entries {
+			bts, err := json.Marshal(entry)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(bts)
+			buf.WriteByte('\n')
+		}
+
+		if err := writeFileAtomic(s.logFile(), buf.Bytes(), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	{ // Update config
+		if len(entries) > 0 {
+			s.config.LastEntry = entries[len(entries)-1]
+		}
+		if err := s.saveConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only remove the old status files once nothing refers to them:
+	referenced := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		referenced[entry.StatusFile] = true
+	}
+	for file := range obsolete {
+		if referenced[file] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(statusPath, file)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	for file := range referenced {
+		if file == "" {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(statusPath, file)); err == nil {
+			result.SizeAfter += fi.Size()
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
+
+// gcDropSet returns the indexes of the entries whose status should be
+// dropped.
+func gcDropSet(entries []*LogEntry, options *GCOptions) (map[int]bool, error) {
+	drop := map[int]bool{}
+	last := len(entries) - 1
+
+	if options.Keep > 0 {
+		for i := 0; i < len(entries)-options.Keep; i++ {
+			drop[i] = true
+		}
+	}
+
+	for _, want := range options.Drop {
+		found := false
+		for i, entry := range entries {
+			if entry.Time.Equal(want.Time) && entry.StatusFile == want.StatusFile {
+				if i == last {
+					return nil, fmt.Errorf("prj: can't drop the status of the last mark")
+				}
+				drop[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("prj: mark at %s not found in log", want.Time)
+		}
+	}
+
+	return drop, nil
+}
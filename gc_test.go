@@ -0,0 +1,208 @@
+package prj
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGCTestProject creates a project with three marks; the first two share a
+// status file, as a forced mark of an unchanged project does.
+func newGCTestProject(t *testing.T) *SimpleProject {
+	t.Helper()
+
+	ctx := context.Background()
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	proj := newTestProject(t, map[string]string{"foo": "foo"}, at)
+	if _, err := proj.Mark(ctx, testSession, "forced", at.Add(time.Hour), &MarkOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(proj.dataRoot, "foo"), "changed")
+	if _, err := proj.Mark(ctx, testSession, "changed", at.Add(2*time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	return proj
+}
+
+func TestGCDrop(t *testing.T) {
+	for idx, tc := range []struct {
+		name    string
+		options func(entries []*LogEntry) *GCOptions
+		dropped []bool
+		files   int
+		err     string
+	}{
+		{
+			name:    "nothing",
+			options: func(entries []*LogEntry) *GCOptions { return nil },
+			dropped: []bool{false, false, false},
+			files:   2,
+		},
+		{
+			// The second mark still refers to the first mark's status file:
+			name:    "keep-shared",
+			options: func(entries []*LogEntry) *GCOptions { return &GCOptions{Keep: 2} },
+			dropped: []bool{true, false, false},
+			files:   2,
+		},
+		{
+			name:    "keep-last",
+			options: func(entries []*LogEntry) *GCOptions { return &GCOptions{Keep: 1} },
+			dropped: []bool{true, true, false},
+			files:   1,
+		},
+		{
+			name:    "drop-shared",
+			options: func(entries []*LogEntry) *GCOptions { return &GCOptions{Drop: entries[1:2]} },
+			dropped: []bool{false, true, false},
+			files:   2,
+		},
+		{
+			name:    "drop-last",
+			options: func(entries []*LogEntry) *GCOptions { return &GCOptions{Drop: entries[2:]} },
+			err:     "can't drop the status of the last mark",
+		},
+		{
+			name: "drop-missing",
+			options: func(entries []*LogEntry) *GCOptions {
+				return &GCOptions{Drop: []*LogEntry{{Time: entries[0].Time.Add(time.Minute)}}}
+			},
+			err: "not found in log",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			proj := newGCTestProject(t)
+			before, err := proj.logEntries()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := proj.GC(context.Background(), tc.options(before))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("%d: expected error containing %q, found %v", idx, tc.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+
+			entries, err := proj.logEntries()
+			if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if len(entries) != len(before) {
+				t.Fatalf("%d: expected log entries to be kept, found %d", idx, len(entries))
+			}
+
+			dropped := 0
+			for i, entry := range entries {
+				if (entry.StatusFile == "") != tc.dropped[i] {
+					t.Fatalf("%d: entry %d: expected dropped=%v, found status file %q", idx, i, tc.dropped[i], entry.StatusFile)
+				}
+				if tc.dropped[i] {
+					dropped++
+					continue
+				}
+				if _, err := proj.MarkStatus(entry); err != nil {
+					t.Fatalf("%d: entry %d: %v", idx, i, err)
+				}
+			}
+			if result.Dropped != dropped {
+				t.Fatalf("%d: expected %d dropped, found %d", idx, dropped, result.Dropped)
+			}
+
+			files, err := ioutil.ReadDir(proj.statusPath())
+			if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if len(files) != tc.files {
+				t.Fatalf("%d: expected %d status files, found %d", idx, tc.files, len(files))
+			}
+
+			if err := proj.refreshConfig(); err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if same, err := sameLogEntry(proj.config.LastEntry, entries[len(entries)-1]); err != nil || !same {
+				t.Fatalf("%d: expected config's last entry to match the log: %v", idx, err)
+			}
+		})
+	}
+}
+
+func TestGCConvert(t *testing.T) {
+	ctx := context.Background()
+	proj := newGCTestProject(t)
+
+	// Rewrite the shared status file in the legacy format:
+	entries, err := proj.logEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact := entries[0].StatusFile
+	legacy := strings.TrimSuffix(compact, statusFileCompactExt) + statusFileExt
+	status, err := proj.MarkStatus(entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(proj.statusPath(), legacy), string(bts))
+	if err := os.Remove(filepath.Join(proj.statusPath(), compact)); err != nil {
+		t.Fatal(err)
+	}
+
+	var log []byte
+	for _, entry := range entries {
+		if entry.StatusFile == compact {
+			entry.StatusFile = legacy
+		}
+		bts, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log = append(append(log, bts...), '\n')
+	}
+	writeTestFile(t, proj.logFile(), string(log))
+
+	result, err := proj.GC(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Converted != 1 || result.Dropped != 0 {
+		t.Fatalf("expected one shared status file converted, found %+v", result)
+	}
+
+	entries, err = proj.logEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].StatusFile != compact || entries[1].StatusFile != compact {
+		t.Fatalf("expected both marks to use %q, found %q and %q", compact, entries[0].StatusFile, entries[1].StatusFile)
+	}
+	if _, err := os.Stat(filepath.Join(proj.statusPath(), legacy)); !os.IsNotExist(err) {
+		t.Fatalf("expected legacy status file to be removed: %v", err)
+	}
+	converted, err := proj.MarkStatus(entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted.Hash.String() != entries[0].Hash.String() {
+		t.Fatalf("expected hash %s, found %s", entries[0].Hash, converted.Hash)
+	}
+
+	fsck, err := proj.Fsck(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fsck.Problems) != 0 {
+		t.Fatalf("expected no problems after gc, found %v", fsck.Problems)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -14,6 +16,7 @@ import (
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/shabbyrobe/golib/errtools"
@@ -43,19 +46,24 @@ func containsGitProjectUnchecked(dir string) (ok bool, err error) {
 }
 
 func LoadGitProject(path string) (*GitProject, error) {
-	h, err := gitReadID(path)
+	id, err := gitReadID(path)
 	if err != nil {
 		return nil, err
 	}
 
 	return &GitProject{
 		path: path,
-		id:   hex.EncodeToString(h[:]),
+		id:   id,
 	}, nil
 }
 
+// ID is the hash of the repo's root commit, or empty if the repo is Unborn.
 func (g *GitProject) ID() string { return g.id }
 
+// Unborn reports whether the repo has no commits yet, so it has no ID and no
+// log. Unborn repos can't be told apart from each other.
+func (g *GitProject) Unborn() bool { return g.id == "" }
+
 func (g *GitProject) Name() string {
 	_, name := filepath.Split(strings.TrimRight(g.path, string(filepath.Separator)))
 	return name
@@ -64,95 +72,408 @@ func (g *GitProject) Name() string {
 func (g *GitProject) Path() string      { return g.path }
 func (g *GitProject) Kind() ProjectKind { return ProjectGit }
 
+// LastEntry returns the entry for the commit at HEAD, or nil if HEAD is
+// unborn. Counting the files in the commit's tree is too slow to do here, so
+// FilesCount is not available.
 func (g *GitProject) LastEntry() (*LogEntry, error) {
-	return nil, nil
-	// This stuff is too slow:
-	/*
-		repo, err := git.PlainOpen(g.path)
-		if err != nil {
-			return nil, err
+	s, err := gitOpenStorage(g.path)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := storer.ResolveReference(s, plumbing.HEAD)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	commit, err := object.GetCommit(s, ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	entry := gitLogEntry(commit)
+	return &entry, nil
+}
+
+// Status hashes the files in the worktree using git's blob hash, so the
+// result can be compared against HEAD without reading every blob. Files that
+// are ignored by git are skipped unless they are already in the index.
+func (g *GitProject) Status(ctx context.Context, childPath ResourcePath, at time.Time, options *StatusOptions) (*ProjectStatus, error) {
+	s, err := gitOpenStorage(g.path)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := s.Index()
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]struct{}, len(idx.Entries))
+	trackedDirs := map[string]struct{}{}
+	for _, e := range idx.Entries {
+		tracked[e.Name] = struct{}{}
+		for dir := path.Dir(e.Name); dir != "."; dir = path.Dir(dir) {
+			trackedDirs[dir] = struct{}{}
 		}
-		ref, err := repo.Head()
+	}
+
+	ignore := newIgnoreMatcher(g.path, ".gitignore")
+	if dot, err := openDotGit(g.path); err != nil {
+		return nil, err
+	} else if err := ignore.loadFile(filepath.Join(dot.Root(), "info", "exclude"), nil); err != nil {
+		return nil, err
+	}
+
+	var files []ProjectFile
+
+	if err := filepath.Walk(filepath.Join(g.path, string(childPath)), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return nil, err
+			return err
 		}
-		obj, err := repo.CommitObject(ref.Hash())
-		if err != nil {
-			return nil, err
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		iter, err := obj.Files()
+
+		rel, err := filepath.Rel(g.path, path)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		parts := splitResourcePath(rel)
 
-		n := 0
-		for {
-			_, err := iter.Next()
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				return nil, err
+		if info.IsDir() {
+			if len(parts) == 0 {
+				return nil
+			}
+			if info.Name() == ".git" {
+				return filepath.SkipDir
 			}
-			n++
+			if _, ok := tracked[filepath.ToSlash(rel)]; ok {
+				// Submodules appear in the index as a single entry for the
+				// directory; their contents belong to another repo.
+				return filepath.SkipDir
+			}
+			if ok, err := containsGitProjectUnchecked(path); err != nil {
+				return err
+			} else if ok {
+				return filepath.SkipDir
+			}
+			if _, ok := trackedDirs[filepath.ToSlash(rel)]; ok {
+				// Tracked files inside an ignored directory are still tracked.
+				return nil
+			}
+			if ignored, err := ignore.MatchAny(parts, true); err != nil {
+				return err
+			} else if ignored {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		iter.Close()
 
-		hash := ref.Hash()
-		var lastEntry = LogEntry{
-			Author:    obj.Author.String(),
-			Message:   obj.Message,
-			Hash:      Hash{Value: hash[:]},
-			FileCount: n,
-			Time:      obj.Author.When,
+		if info.Mode()&(os.ModeSocket|os.ModeNamedPipe|os.ModeDevice) != 0 {
+			return nil
 		}
-		return &lastEntry, nil
-	*/
+
+		if _, ok := tracked[filepath.ToSlash(rel)]; !ok {
+			if ignored, err := ignore.MatchAny(parts, false); err != nil {
+				return err
+			} else if ignored {
+				return nil
+			}
+		}
+
+		hash, err := gitHashWorktreeFile(path, info)
+		if err != nil {
+			return fmt.Errorf("prj: hash file %q failed: %w", path, err)
+		}
+
+		files = append(files, ProjectFile{
+			Name:    ResourcePath(rel),
+			Hash:    hash,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		})
+
+		return nil
+
+	}); err != nil {
+		return nil, err
+	}
+
+	return NewProjectStatus(files, at), nil
 }
 
-func (g *GitProject) Status(ctx context.Context, path ResourcePath, at time.Time) (*ProjectStatus, error) {
-	return nil, fmt.Errorf("prj: not implemented")
+// Diff compares the worktree against the tree at HEAD. Staged changes are not
+// treated any differently to unstaged ones.
+func (g *GitProject) Diff(ctx context.Context, path ResourcePath, at time.Time, options *StatusOptions) (*ProjectDiff, error) {
+	currentStatus, err := g.Status(ctx, path, at, options)
+	if err != nil {
+		return nil, err
+	}
+
+	headStatus, err := g.headStatus(ctx, at)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		headStatus = headStatus.Filter(path, at)
+	}
+
+	return currentStatus.CompareTo(headStatus)
 }
 
-func (g *GitProject) Diff(ctx context.Context, path ResourcePath, at time.Time) (*ProjectDiff, error) {
-	return nil, fmt.Errorf("prj: not implemented")
+// LastEntryStatus returns the files in the HEAD commit, hashed with
+// HashGitSHA1.
+func (g *GitProject) LastEntryStatus(ctx context.Context) (*ProjectStatus, error) {
+	return g.headStatus(ctx, time.Now())
+}
+
+func (g *GitProject) headStatus(ctx context.Context, at time.Time) (*ProjectStatus, error) {
+	s, err := gitOpenStorage(g.path)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := storer.ResolveReference(s, plumbing.HEAD)
+	if err == plumbing.ErrReferenceNotFound {
+		// Unborn HEAD; everything in the worktree is new.
+		return NewProjectStatus(nil, at), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	commit, err := object.GetCommit(s, ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []ProjectFile
+	if err := tree.Files().ForEach(func(f *object.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		files = append(files, ProjectFile{
+			Name:    NewResourcePath(f.Name),
+			Hash:    Hash{Algorithm: HashGitSHA1, Value: HashValue(f.Hash[:])},
+			Size:    f.Size,
+			ModTime: commit.Committer.When,
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return NewProjectStatus(files, at), nil
 }
 
 func (g *GitProject) Mark(ctx context.Context, session *Session, message string, at time.Time, options *MarkOptions) (*ProjectStatus, error) {
 	return nil, fmt.Errorf("prj: not implemented")
 }
 
+// Log yields the commits reachable from HEAD, newest first. Entries are
+// produced lazily, so FilesCount and FilesChanged are not available.
 func (g *GitProject) Log() LogIterator {
-	panic("not implemented")
+	s, err := gitOpenStorage(g.path)
+	if err != nil {
+		return &errLogIterator{err}
+	}
+
+	ref, err := storer.ResolveReference(s, plumbing.HEAD)
+	if err == plumbing.ErrReferenceNotFound {
+		return &nilLogIterator{}
+	} else if err != nil {
+		return &errLogIterator{err}
+	}
+
+	head, err := object.GetCommit(s, ref.Hash())
+	if err != nil {
+		return &errLogIterator{err}
+	}
+
+	return &gitLogIterator{iter: object.NewCommitIterCTime(head, nil, nil)}
 }
 
 func (g *GitProject) Tagger() Tagger {
 	return fileTaggerFromDir(g.path)
 }
 
-// Read the ID of a git repository (probably first revision's hash)
+const gitIDCacheFile = "prj-id" // Child of the git dir
+
+// gitReadID reads the ID of a git repository, which is the hash of its root
+// commit, so it is the same in every clone and doesn't change as commits are
+// added. If HEAD's history has several roots (unrelated histories were
+// merged), the root with the earliest commit time is used. The ID is empty if
+// HEAD is unborn, i.e. the repo has no commits yet.
+//
+// Finding the root means walking the whole history, so the result is cached
+// in the git dir along with the HEAD it was found from. When HEAD moves, only
+// the commits that aren't behind the cached HEAD are walked.
 //
 // Custom unrolling of git utilities from go-git is WAY faster than
 // interfacing with go-git directly.
-func gitReadID(path string) (id [20]byte, err error) {
+func gitReadID(path string) (id string, err error) {
 	dot, err := openDotGit(path)
 	if err != nil {
-		return id, err
+		return "", err
 	}
-
 	s := filesystem.NewStorage(dot, cache.NewObjectLRUDefault())
 
 	ref, err := storer.ResolveReference(s, plumbing.HEAD)
 	if err == plumbing.ErrReferenceNotFound {
-		// This means the repo has been initialised but does not contain a commit.
-		// We might be better off catching this error higher up, and allowing the
-		// git project to be returned with an empty, invalid ID, then making sure
-		// we account for "invalid IDs" elsewhere where we might wish to dedupe, etc.
-		return id, &errProjectNotFound{}
+		return "", nil
 	} else if err != nil {
-		return id, err
+		return "", err
+	}
+	head := ref.Hash()
+
+	// The cache is only an optimisation, so if it can't be read or written
+	// (i.e. someone else's read-only repo), the root is found the slow way:
+	cached, _ := gitReadIDCache(dot, gitIDCacheFile)
+	if cached != nil && cached.head == head {
+		return cached.root.String(), nil
+	}
+
+	root, err := gitFindRoot(s, []plumbing.Hash{head}, cached)
+	if err != nil {
+		return "", err
 	}
+	_ = gitWriteIDCache(dot, gitIDCacheFile, &gitIDCache{head: head, root: root})
 
-	return ref.Hash(), nil
+	return root.String(), nil
+}
+
+type gitIDCache struct {
+	head plumbing.Hash
+	root plumbing.Hash
+}
+
+func gitReadIDCache(dot billy.Filesystem, name string) (cached *gitIDCache, rerr error) {
+	f, err := dot.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	bts, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// "<head> <root>\n"; anything else (i.e. a partial write) is ignored.
+	fields := strings.Fields(string(bts))
+	if len(fields) != 2 || !gitIsHash(fields[0]) || !gitIsHash(fields[1]) {
+		return nil, fmt.Errorf("prj: invalid git id cache")
+	}
+	return &gitIDCache{head: plumbing.NewHash(fields[0]), root: plumbing.NewHash(fields[1])}, nil
+}
+
+func gitWriteIDCache(dot billy.Filesystem, name string, cached *gitIDCache) (rerr error) {
+	f, err := dot.Create(name)
+	if err != nil {
+		return err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	_, err = fmt.Fprintf(f, "%s %s\n", cached.head, cached.root)
+	return err
+}
+
+func gitIsHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// gitFindRoot walks the history from 'heads' to find the earliest root
+// commit. If the head of any of the 'cached' entries is reached, its history
+// isn't walked again; its root stands in for the roots behind it.
+//
+// In a shallow clone the real roots are missing, so the earliest commit on
+// the shallow boundary is used instead. The ID will change if the clone is
+// deepened.
+func gitFindRoot(s storer.EncodedObjectStorer, heads []plumbing.Hash, cached ...*gitIDCache) (plumbing.Hash, error) {
+	var root, boundary *object.Commit
+	earliest := func(cur, c *object.Commit) *object.Commit {
+		if cur == nil || c.Committer.When.Before(cur.Committer.When) ||
+			(c.Committer.When.Equal(cur.Committer.When) && c.Hash.String() < cur.Hash.String()) {
+			return c
+		}
+		return cur
+	}
+
+	cachedRoots := make(map[plumbing.Hash]plumbing.Hash, len(cached))
+	for _, c := range cached {
+		if c != nil {
+			cachedRoots[c.head] = c.root
+		}
+	}
+
+	seen := map[plumbing.Hash]bool{}
+	stack := append([]plumbing.Hash(nil), heads...)
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		if cachedRoot, ok := cachedRoots[hash]; ok {
+			if c, err := object.GetCommit(s, cachedRoot); err == nil {
+				root = earliest(root, c)
+				continue
+			}
+			// If the cached root has gone missing, the cache can't be
+			// trusted; walk the history instead.
+		}
+
+		c, err := object.GetCommit(s, hash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if len(c.ParentHashes) == 0 {
+			root = earliest(root, c)
+			continue
+		}
+
+		missing := 0
+		for _, parent := range c.ParentHashes {
+			if err := s.HasEncodedObject(parent); err == plumbing.ErrObjectNotFound {
+				missing++
+			} else if err != nil {
+				return plumbing.ZeroHash, err
+			} else {
+				stack = append(stack, parent)
+			}
+		}
+		if missing == len(c.ParentHashes) {
+			boundary = earliest(boundary, c)
+		}
+	}
+
+	if root != nil {
+		return root.Hash, nil
+	} else if boundary != nil {
+		return boundary.Hash, nil
+	}
+	return plumbing.ZeroHash, fmt.Errorf("prj: git root commit not found")
+}
+
+func gitOpenStorage(path string) (*filesystem.Storage, error) {
+	dot, err := openDotGit(path)
+	if err != nil {
+		return nil, err
+	}
+	return filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), nil
 }
 
 func openDotGit(path string) (billy.Filesystem, error) {
@@ -201,3 +522,79 @@ func dotGitFileToOSFilesystem(path string, fs billy.Filesystem) (bfs billy.Files
 
 	return osfs.New(fs.Join(path, gitdir)), nil
 }
+
+// HashGitBlob calculates the hash git would give the file at 'path' if it
+// were added as a blob, using HashGitSHA1.
+func HashGitBlob(path string) (Hash, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Hash{}, err
+	}
+	return gitHashWorktreeFile(path, info)
+}
+
+// gitHashWorktreeFile calculates the hash git would give the file at 'path'
+// if it were added as a blob. Symlinks are stored by git as a blob containing
+// the link target.
+func gitHashWorktreeFile(path string, info os.FileInfo) (fh Hash, rerr error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return fh, err
+		}
+		h := plumbing.ComputeHash(plumbing.BlobObject, []byte(filepath.ToSlash(target)))
+		return Hash{Algorithm: HashGitSHA1, Value: HashValue(h[:])}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fh, err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	hasher := plumbing.NewHasher(plumbing.BlobObject, info.Size())
+	if _, err := io.Copy(hasher, f); err != nil {
+		return fh, err
+	}
+	h := hasher.Sum()
+	return Hash{Algorithm: HashGitSHA1, Value: HashValue(h[:])}, nil
+}
+
+func gitLogEntry(commit *object.Commit) LogEntry {
+	return LogEntry{
+		Author:       commit.Author.String(),
+		Message:      commit.Message,
+		Hash:         Hash{Algorithm: HashGitSHA1, Value: HashValue(commit.Hash[:])},
+		FilesCount:   -1,
+		FilesChanged: -1,
+		ModTime:      commit.Committer.When,
+		Time:         commit.Committer.When,
+	}
+}
+
+type gitLogIterator struct {
+	iter object.CommitIter
+	err  error
+}
+
+func (gl *gitLogIterator) Next(entry *LogEntry) bool {
+	if gl.err != nil {
+		return false
+	}
+
+	commit, err := gl.iter.Next()
+	if err == io.EOF {
+		return false
+	} else if err != nil {
+		gl.err = err
+		return false
+	}
+
+	*entry = gitLogEntry(commit)
+	return true
+}
+
+func (gl *gitLogIterator) Close() error {
+	gl.iter.Close()
+	return gl.err
+}
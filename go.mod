@@ -15,4 +15,6 @@ require (
 	github.com/shabbyrobe/golib/bytescan v0.0.0-20200928095438-5007efbc6e6f
 	github.com/shabbyrobe/golib/errtools v0.0.0-20200928095438-5007efbc6e6f
 	github.com/shabbyrobe/golib/pathtools v0.0.0-20200928095438-5007efbc6e6f
+	github.com/zeebo/xxh3 v1.0.2
+	golang.org/x/crypto v0.0.0-20200302210943-78000ba7a073
 )
@@ -2,6 +2,7 @@ package prj
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
 	"encoding/json"
@@ -12,6 +13,8 @@ import (
 	"strings"
 
 	"github.com/shabbyrobe/golib/errtools"
+	"github.com/zeebo/xxh3"
+	"golang.org/x/crypto/blake2b"
 )
 
 const DefaultHashAlgorithm = HashSHA512
@@ -51,6 +54,11 @@ func (v *Hash) UnmarshalJSON(bts []byte) error {
 	if err := json.Unmarshal(bts, &s); err != nil {
 		return err
 	}
+	if s == "" {
+		// MarshalJSON writes empty hashes as empty strings
+		*v = Hash{}
+		return nil
+	}
 
 	h, err := ParseHash(s)
 	if err != nil {
@@ -89,10 +97,59 @@ type HashAlgorithm string
 const (
 	HashNone   HashAlgorithm = ""
 	HashSHA512 HashAlgorithm = "sha512"
+
+	// SHA-256 is slower than SHA-512 on 64-bit machines without SHA
+	// extensions, but is useful for interop with 'sha256sum' manifests.
+	HashSHA256 HashAlgorithm = "sha256"
+
+	// BLAKE2b-512 is considerably faster than SHA-512 on most hardware.
+	HashBLAKE2b HashAlgorithm = "blake2b"
+
+	// XXH3 (64-bit) is not a cryptographic hash. It is much faster than the
+	// others and is fine for quick checks for accidental changes, but it
+	// should not be relied on if collisions matter.
+	HashXXH3 HashAlgorithm = "xxh3"
+
+	// HashGitSHA1 is git's object hash, i.e. the SHA-1 of the object header
+	// followed by the content. It can't be used with CreateHasher as the
+	// header requires the size up front; it only exists so that git hashes
+	// can be carried around in a Hash.
+	HashGitSHA1 HashAlgorithm = "gitsha1"
+
+	// HashHgSHA1 is Mercurial's node hash, which also includes the parent
+	// nodes. Like HashGitSHA1, it can't be used with CreateHasher.
+	HashHgSHA1 HashAlgorithm = "hgsha1"
 )
 
+// HashAlgorithms lists the algorithms that can be used to hash a project.
+func HashAlgorithms() []HashAlgorithm {
+	return []HashAlgorithm{HashSHA512, HashSHA256, HashBLAKE2b, HashXXH3}
+}
+
 func (ha HashAlgorithm) IsValid() bool {
-	return ha == HashSHA512
+	return ha.CanCreate() || ha == HashGitSHA1 || ha == HashHgSHA1
+}
+
+// CanCreate reports whether CreateHasher supports this algorithm, i.e. it can
+// be used to hash a project.
+func (ha HashAlgorithm) CanCreate() bool {
+	for _, v := range HashAlgorithms() {
+		if v == ha {
+			return true
+		}
+	}
+	return false
+}
+
+func (ha HashAlgorithm) String() string { return string(ha) }
+
+func (ha *HashAlgorithm) Set(s string) error {
+	v := HashAlgorithm(s)
+	if !v.CanCreate() {
+		return fmt.Errorf("unknown hash algorithm %q", s)
+	}
+	*ha = v
+	return nil
 }
 
 func (ha HashAlgorithm) Sum(hasher hash.Hash, bts []byte) Hash {
@@ -132,6 +189,12 @@ func (ha HashAlgorithm) CreateHasher() (hash.Hash, error) {
 	switch ha {
 	case HashSHA512:
 		return sha512.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashBLAKE2b:
+		return blake2b.New512(nil)
+	case HashXXH3:
+		return xxh3.New(), nil
 	default:
 		return nil, fmt.Errorf("prj: unsupported hash: %q", ha)
 	}
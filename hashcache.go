@@ -0,0 +1,138 @@
+package prj
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	projectHashCacheFile = "hashcache.json" // Child of ProjectPath
+
+	// Files modified this close to the time the status was started are not
+	// cached; the filesystem's mtime resolution may be too coarse to notice a
+	// second write that lands in the same tick as the one we hashed.
+	hashCacheRacyWindow = 2 * time.Second
+)
+
+// hashCache lets Status skip re-hashing files whose stat data has not changed
+// since they were last hashed. It is keyed by path, and an entry is only used
+// if size, mtime, inode and ctime all match.
+type hashCache struct {
+	file    string
+	entries map[ResourcePath]*hashCacheEntry
+	dirty   bool
+}
+
+type hashCacheEntry struct {
+	Size    int64
+	ModTime time.Time
+	Inode   uint64    `json:",omitempty"`
+	CTime   time.Time `json:",omitempty"`
+	Hash    Hash
+}
+
+type hashCacheData struct {
+	Files map[ResourcePath]*hashCacheEntry
+}
+
+func newHashCache(file string) *hashCache {
+	return &hashCache{file: file, entries: map[ResourcePath]*hashCacheEntry{}}
+}
+
+// loadHashCache loads the cache at 'file'. A missing or unreadable cache is
+// not an error; the cache is an optimisation, so we just start from scratch.
+func loadHashCache(file string) *hashCache {
+	hc := newHashCache(file)
+
+	bts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return hc
+	}
+
+	var data hashCacheData
+	if err := json.Unmarshal(bts, &data); err != nil {
+		return hc
+	}
+	if data.Files != nil {
+		hc.entries = data.Files
+	}
+	return hc
+}
+
+func (hc *hashCache) Get(name ResourcePath, info os.FileInfo, algo HashAlgorithm) (hash Hash, ok bool) {
+	entry := hc.entries[name]
+	if entry == nil || entry.Hash.Algorithm != algo {
+		return hash, false
+	}
+
+	inode, ctime := fileInodeCTime(info)
+	if entry.Size != info.Size() ||
+		!entry.ModTime.Equal(info.ModTime()) ||
+		entry.Inode != inode ||
+		!entry.CTime.Equal(ctime) {
+		return hash, false
+	}
+
+	return entry.Hash, true
+}
+
+func (hc *hashCache) Put(name ResourcePath, info os.FileInfo, hash Hash, started time.Time) {
+	if !info.ModTime().Before(started.Add(-hashCacheRacyWindow)) {
+		delete(hc.entries, name)
+		hc.dirty = true
+		return
+	}
+
+	inode, ctime := fileInodeCTime(info)
+	hc.entries[name] = &hashCacheEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Inode:   inode,
+		CTime:   ctime,
+		Hash:    hash,
+	}
+	hc.dirty = true
+}
+
+// Prune removes entries under 'childPath' that were not seen during a walk,
+// so files that have been deleted don't accumulate forever.
+func (hc *hashCache) Prune(childPath ResourcePath, seen map[ResourcePath]struct{}) {
+	for name := range hc.entries {
+		if childPath != "" && !name.isInPath(childPath) {
+			continue
+		}
+		if _, ok := seen[name]; !ok {
+			delete(hc.entries, name)
+			hc.dirty = true
+		}
+	}
+}
+
+func (hc *hashCache) Save() error {
+	if !hc.dirty {
+		return nil
+	}
+
+	bts, err := json.Marshal(&hashCacheData{Files: hc.entries})
+	if err != nil {
+		return err
+	}
+
+	tmpFile := hc.file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, bts, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, hc.file); err != nil {
+		return err
+	}
+
+	hc.dirty = false
+	return nil
+}
+
+func hashCacheFileFromDir(metaRoot string) string {
+	return filepath.Join(metaRoot, ProjectPath, projectHashCacheFile)
+}
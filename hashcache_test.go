@@ -0,0 +1,48 @@
+package prj
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHashCachePrune(t *testing.T) {
+	names := []ResourcePath{"foo", "foo/a", "foo/b", "foobar/a", "foo.txt", "x"}
+	for idx, tc := range []struct {
+		childPath ResourcePath
+		seen      []ResourcePath
+		left      []ResourcePath
+	}{
+		{childPath: "", seen: []ResourcePath{"foo/a"}, left: []ResourcePath{"foo/a"}},
+		{childPath: "foo", seen: []ResourcePath{"foo/a"}, left: []ResourcePath{"foo.txt", "foo/a", "foobar/a", "x"}},
+		{childPath: "foo/", seen: nil, left: []ResourcePath{"foo.txt", "foobar/a", "x"}},
+		{childPath: "foo/a", seen: nil, left: []ResourcePath{"foo", "foo.txt", "foo/b", "foobar/a", "x"}},
+		{childPath: "fo", seen: nil, left: names},
+	} {
+		hc := newHashCache("")
+		for _, name := range names {
+			hc.entries[ResourcePath(filepath.FromSlash(string(name)))] = &hashCacheEntry{}
+		}
+		seen := map[ResourcePath]struct{}{}
+		for _, name := range tc.seen {
+			seen[ResourcePath(filepath.FromSlash(string(name)))] = struct{}{}
+		}
+
+		hc.Prune(ResourcePath(filepath.FromSlash(string(tc.childPath))), seen)
+
+		var left []string
+		for name := range hc.entries {
+			left = append(left, filepath.ToSlash(string(name)))
+		}
+		sort.Strings(left)
+		var expected []string
+		for _, name := range tc.left {
+			expected = append(expected, string(name))
+		}
+		sort.Strings(expected)
+		if strings.Join(left, ",") != strings.Join(expected, ",") {
+			t.Fatalf("%d: %q: expected %v left, found %v", idx, tc.childPath, expected, left)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package prj
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+type hashJob struct {
+	path string
+	name ResourcePath
+	info os.FileInfo
+}
+
+// hashPool hashes files on a bounded number of goroutines. The first error
+// encountered by any worker cancels the pool's context, which the producer
+// should also check so it can stop walking.
+//
+// 'done' is called from the worker goroutines, so it must be safe for
+// concurrent use.
+type hashPool struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	algo   HashAlgorithm
+	jobs   chan hashJob
+	done   func(job hashJob, hash Hash)
+	wg     sync.WaitGroup
+
+	errOnce sync.Once
+	err     error
+}
+
+func newHashPool(ctx context.Context, workers int, algo HashAlgorithm, done func(job hashJob, hash Hash)) *hashPool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	hp := &hashPool{
+		ctx:    ctx,
+		cancel: cancel,
+		algo:   algo,
+		jobs:   make(chan hashJob, workers),
+		done:   done,
+	}
+
+	hp.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go hp.work()
+	}
+	return hp
+}
+
+func (hp *hashPool) work() {
+	defer hp.wg.Done()
+
+	for job := range hp.jobs {
+		if hp.ctx.Err() != nil {
+			continue // Drain
+		}
+
+		hash, err := hashFileContext(hp.ctx, hp.algo, job.path)
+		if err != nil {
+			hp.fail(fmt.Errorf("prj: hash file %q failed: %w", job.path, err))
+			continue
+		}
+		hp.done(job, hash)
+	}
+}
+
+func (hp *hashPool) fail(err error) {
+	hp.errOnce.Do(func() {
+		hp.err = err
+		hp.cancel()
+	})
+}
+
+// Context is cancelled if any job fails or the parent context is done.
+func (hp *hashPool) Context() context.Context { return hp.ctx }
+
+// Submit blocks until a worker is available or the pool's context is done.
+func (hp *hashPool) Submit(job hashJob) error {
+	select {
+	case hp.jobs <- job:
+		return nil
+	case <-hp.ctx.Done():
+		return hp.ctx.Err()
+	}
+}
+
+// Wait must be called exactly once, after the last call to Submit. It returns
+// the first error encountered by any worker, or the parent context's error.
+func (hp *hashPool) Wait() error {
+	close(hp.jobs)
+	hp.wg.Wait()
+	hp.fail(hp.ctx.Err()) // Captures parent cancellation if nothing else failed; also releases the context.
+	return hp.err
+}
+
+func hashFileContext(ctx context.Context, algo HashAlgorithm, file string) (fh Hash, rerr error) {
+	var f *os.File
+	if f, rerr = os.Open(file); rerr != nil {
+		return fh, rerr
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	return algo.Hash(&contextReader{ctx: ctx, rdr: f})
+}
+
+// contextReader allows a long-running hash of a huge file to be interrupted.
+type contextReader struct {
+	ctx context.Context
+	rdr io.Reader
+}
+
+func (cr *contextReader) Read(b []byte) (n int, err error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.rdr.Read(b)
+}
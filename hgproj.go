@@ -30,11 +30,17 @@ func containsHgProjectUnchecked(dir string) (ok bool, err error) {
 	return false, err
 }
 
+var _ Project = &HgProject{}
+
 func LoadHgProject(path string) (*HgProject, error) {
+	id, err := hgReadID(path)
+	if err != nil {
+		return nil, err
+	}
+
 	return &HgProject{
 		path: path,
-		// FIXME: this can be pulled out of the hg repo
-		id: "",
+		id:   id,
 	}, nil
 }
 
@@ -50,13 +56,29 @@ func (g *HgProject) Name() string {
 func (g *HgProject) Path() string      { return g.path }
 func (g *HgProject) Kind() ProjectKind { return ProjectHg }
 
-func (g *HgProject) LastEntry() (*LogEntry, error) { return nil, nil }
+// LastEntry returns the entry for the tip of the changelog, or nil if the
+// repo does not contain any revisions.
+func (g *HgProject) LastEntry() (*LogEntry, error) {
+	cl, err := openHgChangelog(g.path)
+	if err != nil {
+		return nil, err
+	}
+	if cl.Len() == 0 {
+		return nil, nil
+	}
 
-func (g *HgProject) Status(ctx context.Context, path ResourcePath, at time.Time) (*ProjectStatus, error) {
+	var entry LogEntry
+	if err := hgLogEntry(cl, cl.Len()-1, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (g *HgProject) Status(ctx context.Context, path ResourcePath, at time.Time, options *StatusOptions) (*ProjectStatus, error) {
 	return nil, fmt.Errorf("prj: not implemented")
 }
 
-func (g *HgProject) Diff(ctx context.Context, path ResourcePath, at time.Time) (*ProjectDiff, error) {
+func (g *HgProject) Diff(ctx context.Context, path ResourcePath, at time.Time, options *StatusOptions) (*ProjectDiff, error) {
 	return nil, fmt.Errorf("prj: not implemented")
 }
 
@@ -64,10 +86,72 @@ func (g *HgProject) Mark(ctx context.Context, session *Session, message string,
 	return nil, fmt.Errorf("prj: not implemented")
 }
 
+// Log yields the revisions in the changelog, newest first.
 func (g *HgProject) Log() LogIterator {
-	panic("not implemented")
+	cl, err := openHgChangelog(g.path)
+	if err != nil {
+		return &errLogIterator{err}
+	}
+	return &hgLogIterator{changelog: cl, next: cl.Len() - 1}
 }
 
 func (g *HgProject) Tagger() Tagger {
 	return fileTaggerFromDir(g.path)
 }
+
+// hgReadID uses the node hash of revision 0 as the ID, which is stable across
+// clones. A repo with no revisions has an empty ID.
+func hgReadID(path string) (string, error) {
+	indexFile, err := hgChangelogFile(path)
+	if err != nil {
+		return "", err
+	}
+	node, ok, err := readHgRevlogFirstNode(indexFile)
+	if err != nil || !ok {
+		return "", err
+	}
+	return node.String(), nil
+}
+
+func hgLogEntry(cl *hgRevlog, rev int, entry *LogEntry) error {
+	text, err := cl.Revision(rev)
+	if err != nil {
+		return err
+	}
+	cs, err := parseHgChangeset(text)
+	if err != nil {
+		return fmt.Errorf("prj: hg rev %d: %w", rev, err)
+	}
+
+	node := cl.Node(rev)
+	*entry = LogEntry{
+		Author:       cs.User,
+		Message:      cs.Description,
+		Hash:         Hash{Algorithm: HashHgSHA1, Value: HashValue(node[:])},
+		FilesCount:   -1,
+		FilesChanged: len(cs.Files),
+		ModTime:      cs.Time,
+		Time:         cs.Time,
+	}
+	return nil
+}
+
+type hgLogIterator struct {
+	changelog *hgRevlog
+	next      int
+	err       error
+}
+
+func (hl *hgLogIterator) Next(entry *LogEntry) bool {
+	if hl.err != nil || hl.next < 0 {
+		return false
+	}
+	if err := hgLogEntry(hl.changelog, hl.next, entry); err != nil {
+		hl.err = err
+		return false
+	}
+	hl.next--
+	return true
+}
+
+func (hl *hgLogIterator) Close() error { return hl.err }
@@ -0,0 +1,393 @@
+package prj
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+// This is a minimal, read-only implementation of Mercurial's revlog format,
+// just enough to read the changelog out of a repo without shelling out to
+// 'hg'. It supports RevlogNG (version 1), inline or with a separate data file,
+// with or without generaldelta, and zlib or uncompressed chunks. It does not
+// support zstd-compressed revlogs.
+//
+// See https://www.mercurial-scm.org/wiki/RevlogNG for the format.
+
+const (
+	hgRevlogEntrySize    = 64
+	hgRevlogVersionNG    = 1
+	hgRevlogFlagInline   = 1 << 16
+	hgRevlogFlagGenDelta = 1 << 17
+	hgRevlogNullRev      = -1
+)
+
+type hgNode [20]byte
+
+func (n hgNode) String() string { return fmt.Sprintf("%x", n[:]) }
+
+type hgRevlogEntry struct {
+	offset   int64
+	compLen  int
+	base     int
+	linkRev  int
+	p1, p2   int
+	node     hgNode
+	dataFrom int64 // Offset of the chunk in whichever file contains the data
+}
+
+type hgRevlog struct {
+	indexFile    string
+	dataFile     string
+	inline       bool
+	generalDelta bool
+	entries      []hgRevlogEntry
+
+	// Inline revlogs keep their data interleaved with the index, so we hang
+	// on to the whole thing:
+	inlineData []byte
+}
+
+// openHgChangelog finds and opens the changelog for the repository at 'path'.
+func openHgChangelog(path string) (*hgRevlog, error) {
+	indexFile, err := hgChangelogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return openHgRevlog(indexFile)
+}
+
+// hgChangelogFile finds the changelog's index file for the repository at
+// 'path'. Repos with the 'store' requirement keep it in '.hg/store', older
+// repos keep it in '.hg' directly. Store repos also contain a dummy
+// '.hg/00changelog.i' to stop ancient clients from reading them, so the
+// requirement must be checked rather than probing for files.
+//
+// Repos made by 'hg share' have no store of their own; '.hg/sharedpath'
+// points to the '.hg' of the repo whose store they use. With 'share-safe',
+// which implies 'store', the store's requirements are kept in the store
+// rather than in '.hg/requires'.
+func hgChangelogFile(path string) (string, error) {
+	hgPath := filepath.Join(path, ".hg")
+
+	requires, err := readHgRequires(filepath.Join(hgPath, "requires"))
+	if err != nil {
+		return "", err
+	}
+
+	storeBase := hgPath
+	if requires["shared"] || requires["relshared"] {
+		bts, err := ioutil.ReadFile(filepath.Join(hgPath, "sharedpath"))
+		if err != nil {
+			return "", fmt.Errorf("prj: hg share at %q: %w", path, err)
+		}
+		storeBase = strings.TrimRight(string(bts), "\r\n")
+		if !filepath.IsAbs(storeBase) {
+			storeBase = filepath.Join(hgPath, storeBase)
+		}
+		storeBase = filepath.Clean(storeBase)
+
+		shared, err := readHgRequires(filepath.Join(storeBase, "requires"))
+		if err != nil {
+			return "", err
+		}
+		for req := range shared {
+			requires[req] = true
+		}
+	}
+
+	if requires["store"] || requires["share-safe"] {
+		return filepath.Join(storeBase, "store", "00changelog.i"), nil
+	}
+	return filepath.Join(storeBase, "00changelog.i"), nil
+}
+
+// readHgRequires reads a requirements file. A missing file has no
+// requirements, as in repos from before they were introduced.
+func readHgRequires(file string) (map[string]bool, error) {
+	requires := map[string]bool{}
+	bts, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return requires, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, req := range strings.Fields(string(bts)) {
+		requires[req] = true
+	}
+	return requires, nil
+}
+
+// readHgRevlogFirstNode reads the node of rev 0 without reading the rest of
+// the revlog, which for an inline changelog includes all of the data. ok is
+// false if the revlog is empty.
+func readHgRevlogFirstNode(indexFile string) (node hgNode, ok bool, rerr error) {
+	f, err := os.Open(indexFile)
+	if os.IsNotExist(err) {
+		return node, false, nil
+	} else if err != nil {
+		return node, false, err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	var raw [hgRevlogEntrySize]byte
+	n, err := io.ReadFull(f, raw[:])
+	if n == 0 && err == io.EOF {
+		return node, false, nil
+	} else if err == io.ErrUnexpectedEOF {
+		return node, false, fmt.Errorf("prj: hg revlog %q is truncated at rev 0", indexFile)
+	} else if err != nil {
+		return node, false, err
+	}
+
+	header := binary.BigEndian.Uint32(raw[:])
+	if version := header & 0xFFFF; version != hgRevlogVersionNG {
+		return node, false, fmt.Errorf("prj: hg revlog %q has unsupported version %d", indexFile, version)
+	}
+	copy(node[:], raw[32:52])
+	return node, true, nil
+}
+
+func openHgRevlog(indexFile string) (*hgRevlog, error) {
+	rl := &hgRevlog{
+		indexFile: indexFile,
+		dataFile:  strings.TrimSuffix(indexFile, ".i") + ".d",
+	}
+
+	bts, err := ioutil.ReadFile(indexFile)
+	if os.IsNotExist(err) {
+		// An empty repo has no changelog yet; that's the same as an empty one.
+		return rl, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(bts) == 0 {
+		return rl, nil
+	}
+	if len(bts) < 4 {
+		return nil, fmt.Errorf("prj: hg revlog %q is truncated", indexFile)
+	}
+
+	header := binary.BigEndian.Uint32(bts)
+	if version := header & 0xFFFF; version != hgRevlogVersionNG {
+		return nil, fmt.Errorf("prj: hg revlog %q has unsupported version %d", indexFile, version)
+	}
+	rl.inline = header&hgRevlogFlagInline != 0
+	rl.generalDelta = header&hgRevlogFlagGenDelta != 0
+
+	var pos int64
+	for rev := 0; pos < int64(len(bts)); rev++ {
+		if pos+hgRevlogEntrySize > int64(len(bts)) {
+			return nil, fmt.Errorf("prj: hg revlog %q is truncated at rev %d", indexFile, rev)
+		}
+		raw := bts[pos : pos+hgRevlogEntrySize]
+
+		var e hgRevlogEntry
+		e.offset = int64(binary.BigEndian.Uint64(raw[0:8]) >> 16)
+		if rev == 0 {
+			e.offset = 0 // The first 4 bytes are overlaid with the header.
+		}
+		e.compLen = int(int32(binary.BigEndian.Uint32(raw[8:12])))
+		e.base = int(int32(binary.BigEndian.Uint32(raw[16:20])))
+		e.linkRev = int(int32(binary.BigEndian.Uint32(raw[20:24])))
+		e.p1 = int(int32(binary.BigEndian.Uint32(raw[24:28])))
+		e.p2 = int(int32(binary.BigEndian.Uint32(raw[28:32])))
+		copy(e.node[:], raw[32:52])
+
+		pos += hgRevlogEntrySize
+		if rl.inline {
+			e.dataFrom = pos
+			pos += int64(e.compLen)
+		} else {
+			e.dataFrom = e.offset
+		}
+
+		rl.entries = append(rl.entries, e)
+	}
+
+	if rl.inline {
+		rl.inlineData = bts
+	}
+
+	return rl, nil
+}
+
+func (rl *hgRevlog) Len() int { return len(rl.entries) }
+
+func (rl *hgRevlog) Node(rev int) hgNode { return rl.entries[rev].node }
+
+// Revision reconstructs the full text of 'rev' by applying its delta chain.
+func (rl *hgRevlog) Revision(rev int) (out []byte, rerr error) {
+	if rev < 0 || rev >= len(rl.entries) {
+		return nil, fmt.Errorf("prj: hg revlog %q has no rev %d", rl.indexFile, rev)
+	}
+
+	var chain []int
+	for cur := rev; ; {
+		chain = append(chain, cur)
+		base := rl.entries[cur].base
+		if base == cur {
+			break
+		}
+		if rl.generalDelta {
+			cur = base
+		} else {
+			cur--
+		}
+		if cur < 0 {
+			return nil, fmt.Errorf("prj: hg revlog %q has a broken delta chain at rev %d", rl.indexFile, rev)
+		}
+	}
+
+	var data *os.File
+	if !rl.inline {
+		var err error
+		if data, err = os.Open(rl.dataFile); err != nil {
+			return nil, err
+		}
+		defer errtools.DeferClose(&rerr, data)
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		chunk, err := rl.chunk(data, chain[i])
+		if err != nil {
+			return nil, err
+		}
+		if i == len(chain)-1 {
+			out = chunk
+		} else if out, err = hgApplyDelta(out, chunk); err != nil {
+			return nil, fmt.Errorf("prj: hg revlog %q rev %d: %w", rl.indexFile, chain[i], err)
+		}
+	}
+
+	return out, nil
+}
+
+func (rl *hgRevlog) chunk(data *os.File, rev int) ([]byte, error) {
+	e := rl.entries[rev]
+
+	var raw []byte
+	if rl.inline {
+		end := e.dataFrom + int64(e.compLen)
+		if end > int64(len(rl.inlineData)) {
+			return nil, fmt.Errorf("prj: hg revlog %q is truncated at rev %d", rl.indexFile, rev)
+		}
+		raw = rl.inlineData[e.dataFrom:end]
+	} else {
+		raw = make([]byte, e.compLen)
+		if _, err := data.ReadAt(raw, e.dataFrom); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(raw) == 0 {
+		return raw, nil
+	}
+
+	switch raw[0] {
+	case 0:
+		return raw, nil
+	case 'u':
+		return raw[1:], nil
+	case 'x':
+		zr, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(zr)
+	default:
+		return nil, fmt.Errorf("prj: hg revlog %q rev %d uses unsupported compression %q", rl.indexFile, rev, raw[0])
+	}
+}
+
+// hgApplyDelta applies a revlog 'bdiff' delta, which is a series of hunks
+// replacing base[start:end] with the hunk's data.
+func hgApplyDelta(base []byte, delta []byte) ([]byte, error) {
+	out := make([]byte, 0, len(base))
+	last := 0
+	for len(delta) > 0 {
+		if len(delta) < 12 {
+			return nil, fmt.Errorf("delta hunk truncated")
+		}
+		start := int(binary.BigEndian.Uint32(delta[0:4]))
+		end := int(binary.BigEndian.Uint32(delta[4:8]))
+		size := int(binary.BigEndian.Uint32(delta[8:12]))
+		delta = delta[12:]
+
+		if start < last || end < start || end > len(base) || size > len(delta) {
+			return nil, fmt.Errorf("delta hunk out of range")
+		}
+
+		out = append(out, base[last:start]...)
+		out = append(out, delta[:size]...)
+		delta = delta[size:]
+		last = end
+	}
+	out = append(out, base[last:]...)
+	return out, nil
+}
+
+type hgChangeset struct {
+	Manifest    string
+	User        string
+	Time        time.Time
+	Files       []string
+	Description string
+}
+
+// parseHgChangeset parses the text of a changelog revision:
+//
+//	<manifest node hex>\n
+//	<user>\n
+//	<unixtime> <tz offset, seconds west of UTC>[ <extra>]\n
+//	<file>\n
+//	...
+//	\n
+//	<description>
+func parseHgChangeset(text []byte) (*hgChangeset, error) {
+	var cs hgChangeset
+
+	head, desc := text, []byte(nil)
+	if idx := bytes.Index(text, []byte("\n\n")); idx >= 0 {
+		head, desc = text[:idx], text[idx+2:]
+	}
+	cs.Description = string(desc)
+
+	lines := strings.Split(string(head), "\n")
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("prj: hg changeset is malformed")
+	}
+	cs.Manifest, cs.User = lines[0], lines[1]
+
+	dateParts := strings.Fields(lines[2])
+	if len(dateParts) < 2 {
+		return nil, fmt.Errorf("prj: hg changeset date %q is malformed", lines[2])
+	}
+	secs, err := strconv.ParseFloat(dateParts[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("prj: hg changeset date %q is malformed: %w", lines[2], err)
+	}
+	tz, err := strconv.Atoi(dateParts[1])
+	if err != nil {
+		return nil, fmt.Errorf("prj: hg changeset date %q is malformed: %w", lines[2], err)
+	}
+	cs.Time = time.Unix(int64(secs), 0).In(time.FixedZone("", -tz))
+
+	for _, f := range lines[3:] {
+		if f != "" {
+			cs.Files = append(cs.Files, f)
+		}
+	}
+
+	return &cs, nil
+}
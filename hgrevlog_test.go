@@ -0,0 +1,245 @@
+package prj
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixtures in testdata/hg contain these three changesets, as revs 0-2.
+// 'inline.i' is an inline revlog without generaldelta: rev 0 is compressed,
+// rev 1 is an uncompressed delta against rev 0 and rev 2 is an uncompressed
+// full text. 'split.i' keeps its data in 'split.d' and uses generaldelta:
+// rev 0 is an uncompressed full text, revs 1 and 2 are compressed deltas
+// against rev 0.
+var hgRevlogFixtureTexts = []string{
+	"0000000000000000000000000000000000000000\nalice\n1600000000 0\na.txt\n\nfirst",
+	"0000000000000000000000000000000000000000\nalice\n1600000100 0\na.txt\n\nsecond",
+	"0000000000000000000000000000000000000000\nbob\n1600000200 -3600\nb.txt\n\nthird",
+}
+
+var hgRevlogFixtureNodes = []string{
+	"500d81aafe637717a52f8650e54206e64da33d27",
+	"f937c37e949d9efa20d2958af309235c73ec039a",
+	"2dbf44a68b77b15bfa5bc3d66c97892a57402bbe",
+}
+
+func TestOpenHgRevlog(t *testing.T) {
+	for idx, tc := range []struct {
+		file         string
+		inline       bool
+		generalDelta bool
+		texts        []string
+		err          string
+	}{
+		{file: "inline.i", inline: true, texts: hgRevlogFixtureTexts},
+		{file: "split.i", generalDelta: true, texts: hgRevlogFixtureTexts},
+		{file: "empty.i"},
+		{file: "missing.i"},
+		{file: "truncated.i", err: "truncated at rev 0"},
+		{file: "version2.i", err: "unsupported version 2"},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			rl, err := openHgRevlog(filepath.Join("testdata", "hg", tc.file))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("%d: expected error containing %q, found %v", idx, tc.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+
+			if rl.inline != tc.inline || rl.generalDelta != tc.generalDelta {
+				t.Fatalf("%d: expected inline=%v generalDelta=%v, found %v %v", idx, tc.inline, tc.generalDelta, rl.inline, rl.generalDelta)
+			}
+			if rl.Len() != len(tc.texts) {
+				t.Fatalf("%d: expected %d revs, found %d", idx, len(tc.texts), rl.Len())
+			}
+			for rev, want := range tc.texts {
+				if node := rl.Node(rev).String(); node != hgRevlogFixtureNodes[rev] {
+					t.Fatalf("%d: rev %d: expected node %s, found %s", idx, rev, hgRevlogFixtureNodes[rev], node)
+				}
+				text, err := rl.Revision(rev)
+				if err != nil {
+					t.Fatalf("%d: rev %d: %v", idx, rev, err)
+				}
+				if string(text) != want {
+					t.Fatalf("%d: rev %d: expected text %q, found %q", idx, rev, want, text)
+				}
+			}
+		})
+	}
+}
+
+func TestReadHgRevlogFirstNode(t *testing.T) {
+	for idx, tc := range []struct {
+		file string
+		node string
+		ok   bool
+		err  string
+	}{
+		{file: "inline.i", node: hgRevlogFixtureNodes[0], ok: true},
+		{file: "split.i", node: hgRevlogFixtureNodes[0], ok: true},
+		{file: "empty.i"},
+		{file: "missing.i"},
+		{file: "truncated.i", err: "truncated at rev 0"},
+		{file: "version2.i", err: "unsupported version 2"},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			node, ok, err := readHgRevlogFirstNode(filepath.Join("testdata", "hg", tc.file))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("%d: expected error containing %q, found %v", idx, tc.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if ok != tc.ok {
+				t.Fatalf("%d: expected ok=%v, found %v", idx, tc.ok, ok)
+			}
+			if ok && node.String() != tc.node {
+				t.Fatalf("%d: expected node %s, found %s", idx, tc.node, node)
+			}
+		})
+	}
+}
+
+func TestParseHgChangeset(t *testing.T) {
+	for idx, tc := range []struct {
+		text  string
+		user  string
+		unix  int64
+		files []string
+		desc  string
+	}{
+		{text: hgRevlogFixtureTexts[0], user: "alice", unix: 1600000000, files: []string{"a.txt"}, desc: "first"},
+		{text: hgRevlogFixtureTexts[2], user: "bob", unix: 1600000200, files: []string{"b.txt"}, desc: "third"},
+		{text: "m\nu\n1600000000 0 branch:x\n\n", user: "u", unix: 1600000000},
+	} {
+		cs, err := parseHgChangeset([]byte(tc.text))
+		if err != nil {
+			t.Fatalf("%d: %v", idx, err)
+		}
+		if cs.User != tc.user || cs.Time.Unix() != tc.unix || cs.Description != tc.desc {
+			t.Fatalf("%d: unexpected changeset %+v", idx, cs)
+		}
+		if strings.Join(cs.Files, ",") != strings.Join(tc.files, ",") {
+			t.Fatalf("%d: expected files %v, found %v", idx, tc.files, cs.Files)
+		}
+	}
+}
+
+func TestHgChangelogFile(t *testing.T) {
+	inline, err := ioutil.ReadFile(filepath.Join("testdata", "hg", "inline.i"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each case has a repo in 'src' and possibly a share of it in 'share';
+	// "$ROOT" in a file is replaced with the temp dir.
+	for idx, tc := range []struct {
+		name      string
+		files     map[string]string
+		repo      string
+		changelog string
+		err       string
+	}{
+		{name: "no-requires", repo: "src", changelog: "src/.hg/00changelog.i"},
+		{
+			name:      "revlogv1",
+			files:     map[string]string{"src/.hg/requires": "revlogv1\n"},
+			repo:      "src",
+			changelog: "src/.hg/00changelog.i",
+		},
+		{
+			name:      "store",
+			files:     map[string]string{"src/.hg/requires": "revlogv1\nstore\nfncache\n"},
+			repo:      "src",
+			changelog: "src/.hg/store/00changelog.i",
+		},
+		{
+			name:      "share-safe",
+			files:     map[string]string{"src/.hg/requires": "share-safe\n", "src/.hg/store/requires": "revlogv1\nstore\n"},
+			repo:      "src",
+			changelog: "src/.hg/store/00changelog.i",
+		},
+		{
+			name: "shared",
+			files: map[string]string{
+				"src/.hg/requires":     "revlogv1\nstore\n",
+				"share/.hg/requires":   "revlogv1\nshared\nstore\n",
+				"share/.hg/sharedpath": "$ROOT/src/.hg",
+			},
+			repo:      "share",
+			changelog: "src/.hg/store/00changelog.i",
+		},
+		{
+			name: "shared-share-safe",
+			files: map[string]string{
+				"src/.hg/requires":     "share-safe\n",
+				"share/.hg/requires":   "share-safe\nshared\n",
+				"share/.hg/sharedpath": "$ROOT/src/.hg\n",
+			},
+			repo:      "share",
+			changelog: "src/.hg/store/00changelog.i",
+		},
+		{
+			name: "shared-without-store",
+			files: map[string]string{
+				"share/.hg/requires":   "revlogv1\nshared\n",
+				"share/.hg/sharedpath": "$ROOT/src/.hg",
+			},
+			repo:      "share",
+			changelog: "src/.hg/00changelog.i",
+		},
+		{
+			name: "relshared",
+			files: map[string]string{
+				"src/.hg/requires":     "share-safe\n",
+				"share/.hg/requires":   "relshared\nshare-safe\nshared\n",
+				"share/.hg/sharedpath": "../../src/.hg",
+			},
+			repo:      "share",
+			changelog: "src/.hg/store/00changelog.i",
+		},
+		{
+			name:  "shared-missing-sharedpath",
+			files: map[string]string{"share/.hg/requires": "shared\nstore\n"},
+			repo:  "share",
+			err:   "hg share",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(root, tc.repo, ".hg"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			for name, contents := range tc.files {
+				writeTestFile(t, filepath.Join(root, filepath.FromSlash(name)), strings.Replace(contents, "$ROOT", root, -1))
+			}
+
+			repo := filepath.Join(root, tc.repo)
+			changelog, err := hgChangelogFile(repo)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("%d: expected error containing %q, found %v", idx, tc.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if expected := filepath.Join(root, filepath.FromSlash(tc.changelog)); changelog != expected {
+				t.Fatalf("%d: expected %q, found %q", idx, expected, changelog)
+			}
+
+			writeTestFile(t, changelog, string(inline))
+			if id, err := hgReadID(repo); err != nil || id != hgRevlogFixtureNodes[0] {
+				t.Fatalf("%d: expected id %s, found %q: %v", idx, hgRevlogFixtureNodes[0], id, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package prj
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/shabbyrobe/golib/bytescan"
+)
+
+// IgnoreFile contains gitignore-syntax patterns for files that should not be
+// considered part of a simple project. It may appear in any directory of the
+// project; patterns apply relative to the directory that contains the file.
+const IgnoreFile = ".prjignore"
+
+// ignoreMatcher matches paths against gitignore-syntax patterns from
+// per-directory ignore files. The ignore files for a path's ancestors are
+// loaded the first time a path below them is matched, so the matcher can be
+// used during a walk, or to filter an arbitrary list of paths.
+type ignoreMatcher struct {
+	root     string
+	file     string
+	patterns []gitignore.Pattern
+	loaded   map[string]bool
+}
+
+func newIgnoreMatcher(root string, file string) *ignoreMatcher {
+	return &ignoreMatcher{root: root, file: file, loaded: map[string]bool{}}
+}
+
+// loadFile reads patterns from an arbitrary file, scoped to the 'domain'
+// directory, i.e. ".git/info/exclude" is scoped to the root of the worktree.
+func (im *ignoreMatcher) loadFile(file string, domain []string) error {
+	if fi, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if fi.IsDir() {
+		return nil
+	}
+
+	bts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	domain = append([]string(nil), domain...)
+
+	scn := bytescan.NewScanner(bts)
+	for scn.Scan() {
+		line := strings.TrimRight(scn.Text(), "\r\n")
+		if strings.HasPrefix(line, "#") || len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		im.patterns = append(im.patterns, gitignore.ParsePattern(line, domain))
+	}
+	return scn.Err()
+}
+
+// loadParents ensures the ignore file for each directory that contains 'rel'
+// has been read. Parents are always loaded before their children, so patterns
+// in nested ignore files come later and take precedence.
+func (im *ignoreMatcher) loadParents(rel []string) error {
+	for i := 0; i < len(rel); i++ {
+		dir := strings.Join(rel[:i], "/")
+		if im.loaded[dir] {
+			continue
+		}
+		im.loaded[dir] = true
+
+		file := filepath.Join(append(append([]string{im.root}, rel[:i]...), im.file)...)
+		if err := im.loadFile(file, rel[:i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether the path at 'rel' (relative to the matcher's root)
+// is ignored. Later patterns take precedence over earlier ones.
+//
+// Like git, a path inside an ignored directory is not necessarily reported
+// as ignored; callers walking a tree should skip ignored directories, and
+// callers checking arbitrary paths should use MatchAny.
+func (im *ignoreMatcher) Match(rel []string, isDir bool) (bool, error) {
+	if err := im.loadParents(rel); err != nil {
+		return false, err
+	}
+	for i := len(im.patterns) - 1; i >= 0; i-- {
+		if result := im.patterns[i].Match(rel, isDir); result != gitignore.NoMatch {
+			return result == gitignore.Exclude, nil
+		}
+	}
+	return false, nil
+}
+
+// MatchAny reports whether 'rel' or any of its parent directories is ignored.
+func (im *ignoreMatcher) MatchAny(rel []string, isDir bool) (bool, error) {
+	for i := 1; i < len(rel); i++ {
+		if ignored, err := im.Match(rel[:i], true); err != nil || ignored {
+			return ignored, err
+		}
+	}
+	return im.Match(rel, isDir)
+}
+
+// splitResourcePath converts a path relative to a project root into the
+// segmented form used by ignoreMatcher.
+func splitResourcePath(rel string) []string {
+	rel = filepath.ToSlash(rel)
+	if rel == "" || rel == "." {
+		return nil
+	}
+	return strings.Split(rel, "/")
+}
@@ -13,7 +13,10 @@ import (
 )
 
 type initOptions struct {
-	metaPath string
+	metaPath      string
+	hashAlgorithm HashAlgorithm
+	subProjects   SubProjectPolicy
+	objectStore   string
 }
 
 type InitOption func(opts *initOptions)
@@ -22,15 +25,39 @@ func InitWithSeparateMetaPath(metaPath string) InitOption {
 	return func(opts *initOptions) { opts.metaPath = metaPath }
 }
 
+func InitWithHashAlgorithm(algo HashAlgorithm) InitOption {
+	return func(opts *initOptions) { opts.hashAlgorithm = algo }
+}
+
+func InitWithSubProjectPolicy(policy SubProjectPolicy) InitOption {
+	return func(opts *initOptions) { opts.subProjects = policy }
+}
+
+// InitWithObjectStore keeps the content of marked files; see
+// SimpleProject.SetObjectStore.
+func InitWithObjectStore(store string) InitOption {
+	return func(opts *initOptions) { opts.objectStore = store }
+}
+
 func InitSimpleProject(ctx context.Context, session *Session, projectPath string, name string, at time.Time, options ...InitOption) (Project, *SimpleProjectConfig, error) {
 	var opts = initOptions{
-		metaPath: projectPath,
+		metaPath:      projectPath,
+		hashAlgorithm: DefaultHashAlgorithm,
 	}
 	for _, o := range options {
 		o(&opts)
 	}
+	if !opts.hashAlgorithm.CanCreate() {
+		return nil, nil, fmt.Errorf("prj: unsupported hash algorithm %q", opts.hashAlgorithm)
+	}
+	if opts.subProjects != "" && !opts.subProjects.IsValid() {
+		return nil, nil, fmt.Errorf("prj: unknown sub-project policy %q", opts.subProjects)
+	}
+	if opts.objectStore != "" && opts.objectStore != ObjectStoreLocal && !filepath.IsAbs(opts.objectStore) {
+		return nil, nil, fmt.Errorf("prj: object store path %q is not absolute", opts.objectStore)
+	}
 
-	config, err := initSimpleProjectConfig(opts.metaPath, name, at)
+	config, err := initSimpleProjectConfig(opts.metaPath, name, &opts, at)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -47,7 +74,7 @@ func InitSimpleProject(ctx context.Context, session *Session, projectPath string
 	return project, config, nil
 }
 
-func initSimpleProjectConfig(metaPath string, name string, at time.Time) (*SimpleProjectConfig, error) {
+func initSimpleProjectConfig(metaPath string, name string, opts *initOptions, at time.Time) (*SimpleProjectConfig, error) {
 	if !filepath.IsAbs(metaPath) {
 		return nil, fmt.Errorf("prj: input %q is not absolute", metaPath)
 	}
@@ -59,9 +86,12 @@ func initSimpleProjectConfig(metaPath string, name string, at time.Time) (*Simpl
 	}
 
 	config := &SimpleProjectConfig{
-		ID:       createProjectID(),
-		Name:     name,
-		InitDate: at,
+		ID:            createProjectID(),
+		Name:          name,
+		InitDate:      at,
+		HashAlgorithm: opts.hashAlgorithm,
+		SubProjects:   opts.subProjects,
+		ObjectStore:   opts.objectStore,
 	}
 
 	projectPath := filepath.Join(metaPath, ProjectPath)
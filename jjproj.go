@@ -0,0 +1,246 @@
+package prj
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+type JJProject struct {
+	foreignProject
+}
+
+var _ Project = &JJProject{}
+
+func ContainsJJProject(dir string) (ok bool, err error) {
+	if !filepath.IsAbs(dir) {
+		return false, fmt.Errorf("prj: input %q is not absolute", dir)
+	}
+	return containsJJProjectUnchecked(dir)
+}
+
+func containsJJProjectUnchecked(dir string) (ok bool, err error) {
+	if _, err = os.Stat(filepath.Join(dir, ".jj")); err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func LoadJJProject(path string) (*JJProject, error) {
+	id, err := jjReadID(path)
+	if err != nil {
+		return nil, err
+	}
+	return &JJProject{foreignProject{path: path, id: id, kind: ProjectJJ}}, nil
+}
+
+const jjIDCacheFile = "prj-jj-id" // Child of the git dir
+
+// jjReadID finds the git repo that backs the jj repo, and uses its earliest
+// root commit, as git does (see gitReadID). In a colocated repo, jj keeps
+// HEAD on the working copy's parent, so the walk starts there, and the ID is
+// the same as git's. Otherwise it starts from the local bookmarks, which jj
+// exports to refs/heads. The refs jj adds to keep its own commits alive, and
+// the remote bookmarks, are left out: they change with almost every
+// operation, and can bring in unrelated history. The ID is empty if there
+// is nothing to start from yet.
+func jjReadID(path string) (string, error) {
+	gitDir, err := jjGitDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	dot := osfs.New(gitDir)
+	s := filesystem.NewStorage(dot, cache.NewObjectLRUDefault())
+
+	heads, err := jjHeads(s)
+	if err != nil {
+		return "", err
+	} else if len(heads) == 0 {
+		return "", nil
+	}
+
+	// The root is cached for each ref, so a ref that hasn't moved isn't
+	// walked at all, and one that has is walked only as far as any head that
+	// was cached before. It has its own file, as a jj repo can share its git
+	// dir with a git working copy, and the two would keep replacing each
+	// other's entries.
+	cached, _ := jjReadIDCache(dot)
+	var known []*gitIDCache
+	for _, c := range cached {
+		known = append(known, c)
+	}
+
+	var roots []plumbing.Hash
+	var changed = len(cached) != len(heads)
+	var next = make(map[string]*gitIDCache, len(heads))
+	for _, head := range heads {
+		c := cached[head.name]
+		if c == nil || c.head != head.hash {
+			root, err := gitFindRoot(s, []plumbing.Hash{head.hash}, known...)
+			if err != nil {
+				return "", err
+			}
+			c = &gitIDCache{head: head.hash, root: root}
+			known = append(known, c)
+			changed = true
+		}
+		next[head.name] = c
+		roots = append(roots, c.root)
+	}
+	if changed {
+		_ = jjWriteIDCache(dot, next)
+	}
+
+	// Each root is its own root, so this picks the earliest of them the same
+	// way gitFindRoot picks between the roots it finds:
+	root, err := gitFindRoot(s, roots)
+	if err != nil {
+		return "", err
+	}
+	return root.String(), nil
+}
+
+func jjReadIDCache(dot billy.Filesystem) (cached map[string]*gitIDCache, rerr error) {
+	f, err := dot.Open(jjIDCacheFile)
+	if err != nil {
+		return nil, err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	bts, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// "<head> <root> <ref>\n" per ref; lines that aren't (i.e. a partial
+	// write) are ignored, and their refs walked again.
+	cached = map[string]*gitIDCache{}
+	for _, line := range strings.Split(string(bts), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !gitIsHash(fields[0]) || !gitIsHash(fields[1]) {
+			continue
+		}
+		cached[fields[2]] = &gitIDCache{head: plumbing.NewHash(fields[0]), root: plumbing.NewHash(fields[1])}
+	}
+	return cached, nil
+}
+
+func jjWriteIDCache(dot billy.Filesystem, cached map[string]*gitIDCache) (rerr error) {
+	names := make([]string, 0, len(cached))
+	for name := range cached {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %s %s\n", cached[name].head, cached[name].root, name)
+	}
+
+	f, err := dot.Create(jjIDCacheFile)
+	if err != nil {
+		return err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	_, err = f.Write(buf.Bytes())
+	return err
+}
+
+// jjGitDir finds the git repo the jj repo in 'path' keeps its commits in.
+// Secondary workspaces have a file pointing to the repo instead of the repo
+// itself.
+func jjGitDir(path string) (string, error) {
+	jjDir := filepath.Join(path, ".jj")
+	repo := filepath.Join(jjDir, "repo")
+	if fi, err := os.Stat(repo); err != nil {
+		return "", err
+	} else if !fi.IsDir() {
+		bts, err := ioutil.ReadFile(repo)
+		if err != nil {
+			return "", err
+		}
+		repo = strings.TrimSpace(string(bts))
+		if !filepath.IsAbs(repo) {
+			repo = filepath.Join(jjDir, repo)
+		}
+	}
+
+	store := filepath.Join(repo, "store")
+	kind, err := ioutil.ReadFile(filepath.Join(store, "type"))
+	if err != nil {
+		return "", err
+	} else if k := strings.TrimSpace(string(kind)); k != "git" {
+		return "", fmt.Errorf("prj: unsupported jj store type %q in %q", k, path)
+	}
+
+	target, err := ioutil.ReadFile(filepath.Join(store, "git_target"))
+	if err != nil {
+		return "", err
+	}
+	gitDir := strings.TrimSpace(string(target))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(store, gitDir)
+	}
+	return filepath.Clean(gitDir), nil
+}
+
+type jjHead struct {
+	name string
+	hash plumbing.Hash
+}
+
+// jjHeads returns the commits to start the walk for the ID from: HEAD if it
+// points to a commit, or else the local bookmarks, sorted by name.
+func jjHeads(s *filesystem.Storage) (heads []jjHead, err error) {
+	if ref, err := storer.ResolveReference(s, plumbing.HEAD); err == nil {
+		if _, err := object.GetCommit(s, ref.Hash()); err == nil {
+			return []jjHead{{name: plumbing.HEAD.String(), hash: ref.Hash()}}, nil
+		}
+	} else if err != plumbing.ErrReferenceNotFound {
+		return nil, err
+	}
+
+	refs, err := s.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+
+	if err := refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.SymbolicReference || !ref.Name().IsBranch() {
+			return nil
+		}
+
+		// Skip refs to annotated tags, and anything else that isn't a
+		// commit:
+		if _, err := object.GetCommit(s, ref.Hash()); err != nil {
+			return nil
+		}
+		heads = append(heads, jjHead{name: ref.Name().String(), hash: ref.Hash()})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(heads, func(i, j int) bool {
+		return heads[i].name < heads[j].name
+	})
+	return heads, nil
+}
@@ -0,0 +1,179 @@
+package prj
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// testJJRepo is a jj repo in a temp dir, backed by a git repo in the place
+// 'jj git init' puts it.
+type testJJRepo struct {
+	t    *testing.T
+	path string
+	s    *filesystem.Storage
+	at   time.Time
+}
+
+func newTestJJRepo(t *testing.T) *testJJRepo {
+	t.Helper()
+
+	path := t.TempDir()
+	store := filepath.Join(path, ".jj", "repo", "store")
+	writeTestFile(t, filepath.Join(store, "type"), "git\n")
+	writeTestFile(t, filepath.Join(store, "git_target"), "git")
+
+	s := filesystem.NewStorage(osfs.New(filepath.Join(store, "git")), cache.NewObjectLRUDefault())
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return &testJJRepo{t: t, path: path, s: s, at: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// commit adds an empty commit with 'parents'. Each commit is an hour after
+// the last.
+func (r *testJJRepo) commit(msg string, parents ...plumbing.Hash) plumbing.Hash {
+	r.t.Helper()
+
+	tree := r.s.NewEncodedObject()
+	if err := (&object.Tree{}).Encode(tree); err != nil {
+		r.t.Fatal(err)
+	}
+	treeHash, err := r.s.SetEncodedObject(tree)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	r.at = r.at.Add(time.Hour)
+	sig := object.Signature{Name: "test", Email: "test@example.com", When: r.at}
+	commit := &object.Commit{Author: sig, Committer: sig, Message: msg, TreeHash: treeHash, ParentHashes: parents}
+	obj := r.s.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		r.t.Fatal(err)
+	}
+	hash, err := r.s.SetEncodedObject(obj)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return hash
+}
+
+func (r *testJJRepo) ref(name string, hash plumbing.Hash) {
+	r.t.Helper()
+	if err := r.s.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(name), hash)); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func (r *testJJRepo) readID() string {
+	r.t.Helper()
+	id, err := jjReadID(r.path)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return id
+}
+
+func TestJJReadID(t *testing.T) {
+	for idx, tc := range []struct {
+		name  string
+		setup func(r *testJJRepo) plumbing.Hash
+	}{
+		{
+			name: "no-bookmarks",
+			setup: func(r *testJJRepo) plumbing.Hash {
+				r.ref("refs/jj/keep/a", r.commit("kept"))
+				return plumbing.ZeroHash
+			},
+		},
+		{
+			// jj's keep refs and the remote bookmarks can point to older,
+			// unrelated history; only the local bookmarks count.
+			name: "bookmarks",
+			setup: func(r *testJJRepo) plumbing.Hash {
+				keep := r.commit("kept")
+				pages := r.commit("gh-pages")
+				root := r.commit("root")
+				main := r.commit("main", root)
+				r.ref("refs/jj/keep/"+keep.String(), keep)
+				r.ref("refs/remotes/origin/gh-pages", pages)
+				r.ref("refs/heads/main", main)
+				r.ref("refs/heads/topic", r.commit("topic", main))
+				return root
+			},
+		},
+		{
+			name: "earliest-bookmark-root",
+			setup: func(r *testJJRepo) plumbing.Hash {
+				first := r.commit("first")
+				second := r.commit("second")
+				r.ref("refs/heads/b", second)
+				r.ref("refs/heads/a", r.commit("a", first))
+				return first
+			},
+		},
+		{
+			// In a colocated repo, HEAD is the working copy's parent, as
+			// gitReadID would see it:
+			name: "colocated",
+			setup: func(r *testJJRepo) plumbing.Hash {
+				other := r.commit("other")
+				root := r.commit("root")
+				r.ref("HEAD", r.commit("head", root))
+				r.ref("refs/heads/other", other)
+				return root
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestJJRepo(t)
+			expected := tc.setup(r)
+			want := ""
+			if expected != plumbing.ZeroHash {
+				want = expected.String()
+			}
+
+			if id := r.readID(); id != want {
+				t.Fatalf("%d: expected id %q, found %q", idx, want, id)
+			}
+			// Again, from the cache:
+			if id := r.readID(); id != want {
+				t.Fatalf("%d: expected cached id %q, found %q", idx, want, id)
+			}
+		})
+	}
+}
+
+func TestJJReadIDIncremental(t *testing.T) {
+	r := newTestJJRepo(t)
+	root := r.commit("root")
+	middle := r.commit("middle", root)
+	head := r.commit("head", middle)
+	r.ref("refs/heads/main", head)
+	if id := r.readID(); id != root.String() {
+		t.Fatalf("expected id %s, found %s", root, id)
+	}
+
+	// If the history behind the old head were walked again, 'head' would be
+	// on the boundary of what's left, and would become the ID:
+	if err := r.s.DeleteLooseObject(middle); err != nil {
+		t.Fatal(err)
+	}
+	r.ref("refs/jj/keep/x", r.commit("kept"))
+	r.ref("refs/heads/main", r.commit("next", head))
+	if id := r.readID(); id != root.String() {
+		t.Fatalf("expected id %s from the cache, found %s", root, id)
+	}
+
+	// A new bookmark on the same history starts from the cached head too:
+	r.ref("refs/heads/topic", r.commit("topic", head))
+	if id := r.readID(); id != root.String() {
+		t.Fatalf("expected id %s from the cache, found %s", root, id)
+	}
+}
@@ -8,8 +8,78 @@ import (
 
 var defaultPriority = []ProjectKind{ProjectSimple}
 
+// RootMode decides which project wins when a directory and its ancestors
+// contain more than one kind of project.
+type RootMode int
+
+const (
+	// Each kind is looked for in the directory and all of its ancestors
+	// before the next kind is tried, so a kind with a higher priority wins
+	// even if it is further away.
+	RootModePriority RootMode = iota
+
+	// The project nearest to the directory wins. The priority only breaks
+	// ties between kinds in the same directory.
+	RootModeNearest
+)
+
+func (m RootMode) String() string {
+	switch m {
+	case RootModePriority:
+		return "priority"
+	case RootModeNearest:
+		return "nearest"
+	default:
+		return "unknown"
+	}
+}
+
+func (m *RootMode) Set(s string) error {
+	switch s {
+	case "priority":
+		*m = RootModePriority
+	case "nearest":
+		*m = RootModeNearest
+	default:
+		return fmt.Errorf("unknown root mode %q", s)
+	}
+	return nil
+}
+
+// RootPriority decides which project is used when there is more than one
+// that could be.
+type RootPriority struct {
+	// Kinds to look for, highest priority first. FindRoot only looks for
+	// these kinds; Scan checks any kinds that aren't listed after those that
+	// are, in the order they were registered.
+	Kinds []ProjectKind
+
+	Mode RootMode
+}
+
+// order returns the kinds in the order Scan should check them.
+func (p RootPriority) order() []ProjectKind {
+	if len(p.Kinds) == 0 {
+		return ProjectKinds()
+	}
+
+	seen := make(map[ProjectKind]bool, len(p.Kinds))
+	order := make([]ProjectKind, 0, len(p.Kinds))
+	for _, kind := range append(append([]ProjectKind(nil), p.Kinds...), ProjectKinds()...) {
+		if !seen[kind] {
+			seen[kind] = true
+			order = append(order, kind)
+		}
+	}
+	return order
+}
+
 func Load(searchPath string, priority []ProjectKind) (Project, error) {
-	path, kind, err := FindRoot(searchPath, priority)
+	return LoadWithPriority(searchPath, RootPriority{Kinds: priority})
+}
+
+func LoadWithPriority(searchPath string, priority RootPriority) (Project, error) {
+	path, kind, err := FindRootWithPriority(searchPath, priority)
 	if err != nil {
 		return nil, err
 	}
@@ -23,8 +93,13 @@ func Load(searchPath string, priority []ProjectKind) (Project, error) {
 }
 
 func FindRoot(in string, priority []ProjectKind) (dir string, kind ProjectKind, err error) {
-	if priority == nil {
-		priority = defaultPriority
+	return FindRootWithPriority(in, RootPriority{Kinds: priority})
+}
+
+func FindRootWithPriority(in string, priority RootPriority) (dir string, kind ProjectKind, err error) {
+	kinds := priority.Kinds
+	if kinds == nil {
+		kinds = defaultPriority
 	}
 	if !filepath.IsAbs(in) {
 		return "", 0, fmt.Errorf("prj: input %q is not absolute", in)
@@ -37,23 +112,46 @@ func FindRoot(in string, priority []ProjectKind) (dir string, kind ProjectKind,
 		return "", 0, fmt.Errorf("prj: input %q is not a directory", in)
 	}
 
-	for _, kind := range priority {
-		cur := in
-		for {
-			has, err := kind.Contains(cur)
-			if err != nil {
+	switch priority.Mode {
+	case RootModePriority:
+		for _, kind := range kinds {
+			if dir, ok, err := findRootOfKind(in, []ProjectKind{kind}); err != nil {
 				return "", 0, err
-			} else if has {
-				return cur, kind, nil
+			} else if ok {
+				return dir, kind, nil
 			}
+		}
 
-			next := filepath.Dir(cur)
-			if next == cur {
-				break
-			}
-			cur = next
+	case RootModeNearest:
+		if dir, ok, err := findRootOfKind(in, kinds); err != nil {
+			return "", 0, err
+		} else if ok {
+			kind, _, err := detectKindIn(dir, kinds)
+			return dir, kind, err
 		}
+
+	default:
+		return "", 0, fmt.Errorf("prj: unknown root mode %d", priority.Mode)
 	}
 
-	return "", 0, &errProjectNotFound{in, priority}
+	return "", 0, &errProjectNotFound{in, kinds}
+}
+
+// findRootOfKind returns the nearest of 'in' and its ancestors that contains
+// any of 'kinds'.
+func findRootOfKind(in string, kinds []ProjectKind) (dir string, ok bool, err error) {
+	cur := in
+	for {
+		if _, ok, err := detectKindIn(cur, kinds); err != nil {
+			return "", false, err
+		} else if ok {
+			return cur, true, nil
+		}
+
+		next := filepath.Dir(cur)
+		if next == cur {
+			return "", false, nil
+		}
+		cur = next
+	}
 }
@@ -0,0 +1,95 @@
+package prj
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+const projectLockFile = "lock" // Child of ProjectPath
+
+// lock takes an advisory lock on the project's metadata, which must be held
+// while anything in '.prj' is modified. It fails immediately with
+// ErrProjectLocked if another process holds the lock, rather than waiting.
+//
+// The lock file itself is never removed, as removing it would race with
+// another process locking it.
+func (s *SimpleProject) lock() (unlock func() error, err error) {
+	file := filepath.Join(s.metaRoot, ProjectPath, projectLockFile)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if err == errWouldBlock {
+			return nil, &errProjectLocked{Path: s.dataRoot}
+		}
+		return nil, err
+	}
+
+	return func() error {
+		// Closing the file releases the lock.
+		return f.Close()
+	}, nil
+}
+
+// writeFileAtomic writes 'data' to a temporary file next to 'file', syncs it
+// and renames it into place, so readers see either the old or new content
+// and never a partial write, even after a crash.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) (rerr error) {
+	tmp, err := os.OpenFile(file+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(file))
+}
+
+// syncDir makes a rename or new file in 'dir' durable. Not all platforms
+// support syncing a directory, so failures to sync are ignored.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	_ = d.Sync()
+	return d.Close()
+}
+
+func appendFileSync(file string, data []byte, perm os.FileMode) (rerr error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, perm)
+	if err != nil {
+		return err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+type closerFunc func() error
+
+func (fn closerFunc) Close() error { return fn() }
@@ -0,0 +1,254 @@
+package prj
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MarkRefFormats lists the date layouts accepted by ResolveMark. Layouts
+// without a time of day refer to the end of that day in local time.
+var MarkRefFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ResolveMark finds the log entry referred to by 'ref', which can be:
+//
+//   - "~N": the Nth mark before the last one; "~0" is the last mark.
+//   - A date or time in one of the MarkRefFormats: the last mark made at or
+//     before that time.
+//   - Anything else is treated as a hash prefix; see FindMark.
+func (s *SimpleProject) ResolveMark(ref string) (*LogEntry, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("prj: empty mark reference")
+	}
+
+	if strings.HasPrefix(ref, "~") {
+		n, err := strconv.Atoi(ref[1:])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("prj: invalid mark index %q", ref)
+		}
+		entries, err := s.logEntries()
+		if err != nil {
+			return nil, err
+		}
+		if n >= len(entries) {
+			return nil, fmt.Errorf("prj: mark %q not found; project has %d mark(s)", ref, len(entries))
+		}
+		return entries[len(entries)-1-n], nil
+	}
+
+	if at, ok := parseMarkRefTime(ref); ok {
+		entries, err := s.logEntries()
+		if err != nil {
+			return nil, err
+		}
+		var found *LogEntry
+		for _, entry := range entries {
+			if !entry.Time.After(at) {
+				found = entry
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("prj: no mark at or before %s", at.Format(time.RFC3339))
+		}
+		return found, nil
+	}
+
+	return s.FindMark(ref)
+}
+
+func parseMarkRefTime(ref string) (at time.Time, ok bool) {
+	for _, layout := range MarkRefFormats {
+		var err error
+		if layout == time.RFC3339 {
+			at, err = time.Parse(layout, ref)
+		} else {
+			at, err = time.ParseInLocation(layout, ref, time.Local)
+		}
+		if err != nil {
+			continue
+		}
+		if !strings.Contains(layout, "15") {
+			at = at.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return at, true
+	}
+	return at, false
+}
+
+// MarkStatus loads the status that was recorded with 'entry'.
+func (s *SimpleProject) MarkStatus(entry *LogEntry) (*ProjectStatus, error) {
+	if entry.StatusFile == "" {
+		return nil, fmt.Errorf("prj: no status file for log entry at %s", entry.Time)
+	}
+
+	status, err := s.readStatusFile(entry.StatusFile)
+	if err != nil {
+		return nil, fmt.Errorf("prj: could not read status file for log entry at %s: %w", entry.Time, err)
+	}
+	return status, nil
+}
+
+// LastEntryStatus loads the status recorded by the last mark, or nil if the
+// project has no marks.
+func (s *SimpleProject) LastEntryStatus(ctx context.Context) (*ProjectStatus, error) {
+	if err := s.refreshConfig(); err != nil {
+		return nil, err
+	}
+	if s.config.LastEntry == nil {
+		return nil, nil
+	}
+	return s.MarkStatus(s.config.LastEntry)
+}
+
+// ResolveMarkStatus loads the status recorded by the mark 'ref' refers to.
+// See ResolveMark for the supported references.
+func (s *SimpleProject) ResolveMarkStatus(ref string) (*ProjectStatus, *LogEntry, error) {
+	entry, err := s.ResolveMark(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	status, err := s.MarkStatus(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	return status, entry, nil
+}
+
+// DiffMarks compares the marks referred to by 'from' and 'to'. If 'from' is
+// empty, the last mark is used. If 'to' is empty, the current state of the
+// project is used, as with Diff.
+func (s *SimpleProject) DiffMarks(ctx context.Context, path ResourcePath, from, to string, at time.Time, options *StatusOptions) (*ProjectDiff, error) {
+	if err := s.refreshConfig(); err != nil {
+		return nil, err
+	}
+
+	// Both sides must use the same algorithm as the 'to' side, which is the
+	// project's unless 'to' is a mark:
+	algo := s.config.hashAlgorithm()
+
+	var toStatus *ProjectStatus
+	if to == "" {
+		status, err := s.Status(ctx, path, at, options)
+		if err != nil {
+			return nil, err
+		}
+		toStatus = status
+
+	} else {
+		status, _, err := s.ResolveMarkStatus(to)
+		if err != nil {
+			return nil, err
+		}
+		algo = status.Hash.Algorithm
+		if toStatus, err = s.markStatusForDiff(status, path, at); err != nil {
+			return nil, err
+		}
+	}
+
+	fromStatus := &ProjectStatus{}
+	if from != "" {
+		status, entry, err := s.ResolveMarkStatus(from)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkMarkAlgorithm(entry, status, algo); err != nil {
+			return nil, err
+		}
+		if fromStatus, err = s.markStatusForDiff(status, path, at); err != nil {
+			return nil, err
+		}
+
+	} else if s.config.LastEntry != nil {
+		if s.config.LastEntry.StatusFile == "" {
+			return nil, fmt.Errorf("prj: no status file for last log entry, cannot diff")
+		}
+		status, err := s.readStatusFile(s.config.LastEntry.StatusFile)
+		if err != nil {
+			return nil, fmt.Errorf("prj: could not read status file for last log entry, cannot diff; previous error: %v", err)
+		}
+		if err := checkMarkAlgorithm(s.config.LastEntry, status, algo); err != nil {
+			return nil, err
+		}
+		if fromStatus, err = s.markStatusForDiff(status, path, at); err != nil {
+			return nil, err
+		}
+	}
+
+	return toStatus.CompareTo(fromStatus)
+}
+
+// checkMarkAlgorithm returns an error if the status recorded by a mark uses a
+// different hash algorithm to 'algo', as its hashes can't be compared with
+// hashes made by 'algo'. This happens to marks left behind by a forced Rehash.
+func checkMarkAlgorithm(entry *LogEntry, status *ProjectStatus, algo HashAlgorithm) error {
+	if status.Hash.Algorithm != algo {
+		return fmt.Errorf("prj: mark at %s uses hash algorithm %q, not %q, so it can't be compared; see 'prj rehash'",
+			entry.Time.Format(time.RFC3339), status.Hash.Algorithm, algo)
+	}
+	return nil
+}
+
+// markStatusForDiff limits a stored status to 'path', and drops files that
+// have since been ignored; files that were marked before they were ignored
+// should not show up as removed.
+func (s *SimpleProject) markStatusForDiff(status *ProjectStatus, path ResourcePath, at time.Time) (*ProjectStatus, error) {
+	if path != "" {
+		status = status.Filter(path, at)
+	}
+	return s.FilterIgnored(status, at)
+}
+
+// FindMark finds the log entry whose hash starts with 'prefix'. The prefix
+// may include the algorithm (i.e. "sha512:abc") or just the value ("abc").
+func (s *SimpleProject) FindMark(prefix string) (*LogEntry, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("prj: empty mark hash")
+	}
+
+	entries, err := s.logEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *LogEntry
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Hash.String(), prefix) && !strings.HasPrefix(entry.Hash.Value.String(), prefix) {
+			continue
+		}
+		if found != nil && found.Hash.String() != entry.Hash.String() {
+			return nil, fmt.Errorf("prj: mark hash %q is ambiguous", prefix)
+		}
+		found = entry
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("prj: mark %q not found", prefix)
+	}
+	return found, nil
+}
+
+// logEntries reads the whole log, oldest first.
+func (s *SimpleProject) logEntries() ([]*LogEntry, error) {
+	var entries []*LogEntry
+	iter := s.Log()
+	for {
+		var entry LogEntry
+		if !iter.Next(&entry) {
+			break
+		}
+		entries = append(entries, &entry)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
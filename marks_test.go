@@ -0,0 +1,106 @@
+package prj
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/marks/log.jsonl holds four marks, 'one' to 'four', made at noon
+// UTC on 2020-01-01, 2020-01-05 and 2020-01-10, and at 13:00 on 2020-01-10.
+// 'one' and 'four' share a hash; the hash values of 'one' and 'two' share
+// the prefix "AAA".
+func loadMarksFixture(t *testing.T) *SimpleProject {
+	t.Helper()
+
+	bts, err := ioutil.ReadFile(filepath.Join("testdata", "marks", ProjectLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ProjectPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ProjectPath, ProjectLogFile), bts, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &SimpleProject{dataRoot: dir, metaRoot: dir}
+}
+
+func TestResolveMark(t *testing.T) {
+	proj := loadMarksFixture(t)
+
+	for idx, tc := range []struct {
+		ref     string
+		message string
+		err     string
+	}{
+		{ref: "~0", message: "four"},
+		{ref: "~1", message: "three"},
+		{ref: "~3", message: "one"},
+		{ref: "~4", err: `mark "~4" not found; project has 4 mark(s)`},
+		{ref: "~-1", err: "invalid mark index"},
+		{ref: "~x", err: "invalid mark index"},
+
+		// Dates without a time of day are the end of that day in local time;
+		// these are far enough from the marks to hold in any zone.
+		{ref: "2020-01-07", message: "two"},
+		{ref: "2020-01-07 08:00", message: "two"},
+		{ref: "2020-01-20", message: "four"},
+		{ref: "2019-12-30", err: "no mark at or before"},
+		{ref: "2020-01-05T12:00:00Z", message: "two"},
+		{ref: "2020-01-05T11:59:59Z", message: "one"},
+		{ref: "2020-01-10T12:30:00Z", message: "three"},
+
+		{ref: "QUJD", message: "three"},
+		{ref: "sha256:QUJD", message: "three"},
+		{ref: "AAAB", message: "two"},
+		{ref: "AAAA", message: "four"}, // 'one' has the same hash; the last wins
+		{ref: "AAA", err: `mark hash "AAA" is ambiguous`},
+		{ref: "sha256:", err: `mark hash "sha256:" is ambiguous`},
+		{ref: "sha512:QUJD", err: `mark "sha512:QUJD" not found`},
+		{ref: "zzz", err: `mark "zzz" not found`},
+		{ref: "", err: "empty mark reference"},
+	} {
+		entry, err := proj.ResolveMark(tc.ref)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("%d: %q: expected error containing %q, found %v", idx, tc.ref, tc.err, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%d: %q: %v", idx, tc.ref, err)
+		}
+		if entry.Message != tc.message {
+			t.Fatalf("%d: %q: expected mark %q, found %q", idx, tc.ref, tc.message, entry.Message)
+		}
+	}
+}
+
+func TestParseMarkRefTime(t *testing.T) {
+	for idx, tc := range []struct {
+		ref string
+		ok  bool
+	}{
+		{ref: "2020-01-02T03:04:05Z", ok: true},
+		{ref: "2020-01-02T03:04:05+10:00", ok: true},
+		{ref: "2020-01-02T03:04:05", ok: true},
+		{ref: "2020-01-02 03:04", ok: true},
+		{ref: "2020-01-02", ok: true},
+		{ref: "2020-13-02"},
+		{ref: "abc"},
+	} {
+		_, ok := parseMarkRefTime(tc.ref)
+		if ok != tc.ok {
+			t.Fatalf("%d: %q: expected ok=%v, found %v", idx, tc.ref, tc.ok, ok)
+		}
+	}
+
+	at, _ := parseMarkRefTime("2020-01-02")
+	if at.Location() != time.Local || at.Day() != 2 || at.Hour() != 23 || at.Minute() != 59 {
+		t.Fatalf("expected end of day in local time, found %s", at)
+	}
+}
@@ -0,0 +1,382 @@
+package prj
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+const (
+	projectObjectsPath = "objects" // Child of ProjectPath
+
+	// ObjectStoreLocal configures a project to keep its objects in
+	// '.prj/objects'. Any other non-empty value is the path to a shared store.
+	ObjectStoreLocal = "local"
+)
+
+// ObjectStore keeps the content of files, keyed by their Hash, so that marks
+// can be restored. Each object is stored once no matter how many marks or
+// projects refer to it, so a store can be shared between projects as long as
+// they can agree on the hash algorithm; objects with different algorithms are
+// kept apart.
+//
+// Objects are stored at '<root>/<algorithm>/<first 2 hex digits>/<rest>'.
+// Hex is used rather than the base64 used elsewhere, as base64 is not safe to
+// use on case-insensitive filesystems.
+type ObjectStore struct {
+	root string
+}
+
+func NewObjectStore(root string) *ObjectStore {
+	return &ObjectStore{root: root}
+}
+
+func (store *ObjectStore) Root() string { return store.root }
+
+func (store *ObjectStore) Path(hash Hash) (string, error) {
+	if hash.IsEmpty() || !hash.Algorithm.IsValid() {
+		return "", fmt.Errorf("prj: invalid object hash %q", hash)
+	}
+	h := hex.EncodeToString(hash.Value)
+	if len(h) < 3 {
+		return "", fmt.Errorf("prj: invalid object hash %q", hash)
+	}
+	return filepath.Join(store.root, string(hash.Algorithm), h[:2], h[2:]), nil
+}
+
+func (store *ObjectStore) Has(hash Hash) (bool, error) {
+	path, err := store.Path(hash)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Put copies the content of 'file' into the store, if the store doesn't
+// already have it. The content is hashed as it is copied, and the object is
+// discarded if it doesn't match 'hash', i.e. because the file changed after
+// it was hashed.
+func (store *ObjectStore) Put(ctx context.Context, hash Hash, file string) (rerr error) {
+	if ok, err := store.Has(hash); err != nil || ok {
+		return err
+	}
+
+	dest, err := store.Path(hash)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+
+	hasher, err := hash.Algorithm.CreateHasher()
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer errtools.DeferClose(&rerr, src)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	rdr := &contextReader{ctx: ctx, rdr: src}
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), rdr); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if eq, err := hash.Equal(hash.Algorithm.Sum(hasher, nil)); err != nil {
+		return err
+	} else if !eq {
+		return fmt.Errorf("prj: file %q changed while it was being stored", file)
+	}
+
+	// Objects are never modified, so make that harder to do by accident:
+	if err := os.Chmod(tmp.Name(), 0400); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// Link makes the object stored as 'from' available as 'to' as well, for when
+// the same content is hashed with a different algorithm. The object is hard
+// linked if possible, otherwise it is copied and checked against 'to'. ok is
+// false if there is no object stored as 'from'.
+func (store *ObjectStore) Link(ctx context.Context, from, to Hash) (ok bool, rerr error) {
+	if ok, err := store.Has(from); err != nil || !ok {
+		return false, err
+	}
+	if ok, err := store.Has(to); err != nil || ok {
+		return ok, err
+	}
+
+	src, err := store.Path(from)
+	if err != nil {
+		return false, err
+	}
+	dest, err := store.Path(to)
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return false, err
+	}
+	if err := os.Link(src, dest); err == nil || os.IsExist(err) {
+		return true, nil
+	}
+	if err := store.Put(ctx, to, src); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (store *ObjectStore) Open(hash Hash) (io.ReadCloser, error) {
+	path, err := store.Path(hash)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// ObjectStore returns the project's object store, or nil if it doesn't have
+// one.
+func (s *SimpleProject) ObjectStore() (*ObjectStore, error) {
+	if err := s.refreshConfig(); err != nil {
+		return nil, err
+	}
+	switch s.config.ObjectStore {
+	case "":
+		return nil, nil
+	case ObjectStoreLocal:
+		return NewObjectStore(filepath.Join(s.metaRoot, ProjectPath, projectObjectsPath)), nil
+	default:
+		return NewObjectStore(s.config.ObjectStore), nil
+	}
+}
+
+// SetObjectStore enables the object store for future marks. 'store' is
+// either ObjectStoreLocal, or the absolute path to a shared store. If 'store'
+// is empty, the object store is disabled; objects that were already stored
+// are left alone.
+func (s *SimpleProject) SetObjectStore(store string) (rerr error) {
+	if store != "" && store != ObjectStoreLocal && !filepath.IsAbs(store) {
+		return fmt.Errorf("prj: object store path %q is not absolute", store)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer errtools.DeferClose(&rerr, closerFunc(unlock))
+
+	if err := s.refreshConfig(); err != nil {
+		return err
+	}
+	s.config.ObjectStore = store
+	return s.saveConfig()
+}
+
+// storeObjects copies every file in 'status' into the project's object store,
+// if it has one.
+func (s *SimpleProject) storeObjects(ctx context.Context, status *ProjectStatus) error {
+	store, err := s.ObjectStore()
+	if err != nil || store == nil {
+		return err
+	}
+
+	for _, f := range status.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f.SubProject != nil {
+			continue // Sub-projects have their own marks.
+		}
+		if err := store.Put(ctx, f.Hash, filepath.Join(s.dataRoot, string(f.Name))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type RestoreOptions struct {
+	// Replace files whose content differs from the mark. If false, they are
+	// reported in RestoreResult.Conflicts and left alone.
+	Overwrite bool
+}
+
+type RestoreResult struct {
+	// Files that were written.
+	Restored []ResourcePath
+
+	// Files that already matched the mark.
+	Unchanged []ResourcePath
+
+	// Files that differ from the mark, but were left alone because
+	// RestoreOptions.Overwrite was false.
+	Conflicts []ResourcePath
+
+	// Files whose content is not in the object store, i.e. because they were
+	// marked before the store was enabled.
+	Missing []ResourcePath
+}
+
+// Restore puts the files recorded by 'entry' back from the object store.
+// If 'path' is not empty, only files inside it are restored. Files that are
+// not in the mark are never removed.
+func (s *SimpleProject) Restore(ctx context.Context, entry *LogEntry, path ResourcePath, at time.Time, options *RestoreOptions) (*RestoreResult, error) {
+	if options == nil {
+		options = &RestoreOptions{}
+	}
+
+	store, err := s.ObjectStore()
+	if err != nil {
+		return nil, err
+	} else if store == nil {
+		return nil, fmt.Errorf("prj: project has no object store")
+	}
+
+	mark, err := s.MarkStatus(entry)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		mark = s.restoreFilter(mark, path, at)
+	}
+
+	var result RestoreResult
+	for _, f := range mark.Files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if f.SubProject != nil {
+			continue
+		}
+
+		dest := filepath.Join(s.dataRoot, string(f.Name))
+		if info, err := os.Lstat(dest); err == nil {
+			if info.IsDir() {
+				result.Conflicts = append(result.Conflicts, f.Name)
+				continue
+			}
+			current, err := hashFileContext(ctx, f.Hash.Algorithm, dest)
+			if err != nil {
+				return nil, err
+			}
+			if eq, err := current.Equal(f.Hash); err != nil {
+				return nil, err
+			} else if eq {
+				result.Unchanged = append(result.Unchanged, f.Name)
+				continue
+			} else if !options.Overwrite {
+				result.Conflicts = append(result.Conflicts, f.Name)
+				continue
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if ok, err := store.Has(f.Hash); err != nil {
+			return nil, err
+		} else if !ok {
+			result.Missing = append(result.Missing, f.Name)
+			continue
+		}
+
+		if err := restoreObject(ctx, store, f, dest); err != nil {
+			return nil, err
+		}
+		result.Restored = append(result.Restored, f.Name)
+	}
+
+	return &result, nil
+}
+
+// restoreFilter limits status to the file at 'path', or the files inside the
+// directory at 'path'. Filter isn't used, as it matches any name that starts
+// with 'path' (i.e. "foo" matches "foobar"), and Restore overwrites whatever
+// it matches.
+func (s *SimpleProject) restoreFilter(status *ProjectStatus, path ResourcePath, at time.Time) *ProjectStatus {
+	var files []ProjectFile
+	for _, f := range status.Files {
+		if f.Name.isInPath(path) {
+			files = append(files, f)
+		}
+	}
+	return NewProjectStatusWithAlgorithm(files, at, status.Hash.Algorithm)
+}
+
+func restoreObject(ctx context.Context, store *ObjectStore, f ProjectFile, dest string) (rerr error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	src, err := store.Open(f.Hash)
+	if err != nil {
+		return err
+	}
+	defer errtools.DeferClose(&rerr, src)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".prj-restore-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// The object could have rotted too, so check it on the way out:
+	hasher, err := f.Hash.Algorithm.CreateHasher()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), &contextReader{ctx: ctx, rdr: src}); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if eq, err := f.Hash.Equal(f.Hash.Algorithm.Sum(hasher, nil)); err != nil {
+		return err
+	} else if !eq {
+		return fmt.Errorf("prj: stored object for %q is corrupt", f.Name)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), f.ModTime, f.ModTime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
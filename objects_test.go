@@ -0,0 +1,123 @@
+package prj
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestore(t *testing.T) {
+	for idx, tc := range []struct {
+		path      ResourcePath
+		overwrite bool
+		restored  []ResourcePath
+		unchanged []ResourcePath
+		conflicts []ResourcePath
+
+		// Contents of the files after the restore:
+		contents map[string]string
+	}{
+		{
+			path: "", restored: []ResourcePath{"foo/c"},
+			unchanged: []ResourcePath{"x"}, conflicts: []ResourcePath{"foo/a", "foobar/b"},
+			contents: map[string]string{"foo/a": "changed", "foo/c": "c", "foobar/b": "changed"},
+		},
+		{
+			path: "", overwrite: true, restored: []ResourcePath{"foo/a", "foo/c", "foobar/b"},
+			unchanged: []ResourcePath{"x"},
+			contents:  map[string]string{"foo/a": "a", "foo/c": "c", "foobar/b": "b"},
+		},
+		{
+			path: "foo", overwrite: true, restored: []ResourcePath{"foo/a", "foo/c"},
+			contents: map[string]string{"foo/a": "a", "foo/c": "c", "foobar/b": "changed"},
+		},
+		{
+			path: "foo/", overwrite: true, restored: []ResourcePath{"foo/a", "foo/c"},
+			contents: map[string]string{"foo/a": "a", "foo/c": "c", "foobar/b": "changed"},
+		},
+		{
+			path: "foo", restored: []ResourcePath{"foo/c"}, conflicts: []ResourcePath{"foo/a"},
+			contents: map[string]string{"foo/a": "changed", "foo/c": "c", "foobar/b": "changed"},
+		},
+		{
+			path: "foobar", overwrite: true, restored: []ResourcePath{"foobar/b"},
+			contents: map[string]string{"foo/a": "changed", "foobar/b": "b"},
+		},
+		{
+			path: "foo/a", overwrite: true, restored: []ResourcePath{"foo/a"},
+			contents: map[string]string{"foo/a": "a", "foobar/b": "changed"},
+		},
+		{
+			path: "fo", overwrite: true,
+			contents: map[string]string{"foo/a": "changed", "foobar/b": "changed"},
+		},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			ctx := context.Background()
+			at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			proj := newTestProject(t, map[string]string{
+				"foo/a": "a", "foo/c": "c", "foobar/b": "b", "x": "x",
+			}, at, InitWithObjectStore(ObjectStoreLocal))
+			entry := lastTestEntry(t, proj)
+
+			writeTestFile(t, filepath.Join(proj.dataRoot, "foo", "a"), "changed")
+			writeTestFile(t, filepath.Join(proj.dataRoot, "foobar", "b"), "changed")
+			if err := os.Remove(filepath.Join(proj.dataRoot, "foo", "c")); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := proj.Restore(ctx, entry, tc.path, at, &RestoreOptions{Overwrite: tc.overwrite})
+			if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+			if fmt.Sprint(result.Restored) != fmt.Sprint(tc.restored) {
+				t.Fatalf("%d: expected restored %v, found %v", idx, tc.restored, result.Restored)
+			}
+			if fmt.Sprint(result.Unchanged) != fmt.Sprint(tc.unchanged) {
+				t.Fatalf("%d: expected unchanged %v, found %v", idx, tc.unchanged, result.Unchanged)
+			}
+			if fmt.Sprint(result.Conflicts) != fmt.Sprint(tc.conflicts) {
+				t.Fatalf("%d: expected conflicts %v, found %v", idx, tc.conflicts, result.Conflicts)
+			}
+			if len(result.Missing) != 0 {
+				t.Fatalf("%d: expected nothing missing, found %v", idx, result.Missing)
+			}
+
+			for name, want := range tc.contents {
+				bts, err := ioutil.ReadFile(filepath.Join(proj.dataRoot, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatalf("%d: %v", idx, err)
+				}
+				if string(bts) != want {
+					t.Fatalf("%d: %s: expected %q, found %q", idx, name, want, bts)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreMissingObjects(t *testing.T) {
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	proj := newTestProject(t, map[string]string{"foo": "foo"}, at)
+	entry := lastTestEntry(t, proj)
+
+	// Marked before the store was enabled:
+	if err := proj.SetObjectStore(ObjectStoreLocal); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(proj.dataRoot, "foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := proj.Restore(context.Background(), entry, "", at, &RestoreOptions{Overwrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Missing) != "[foo]" || len(result.Restored) != 0 {
+		t.Fatalf("expected foo to be missing, found %+v", result)
+	}
+}
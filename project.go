@@ -19,11 +19,34 @@ func (rp ResourcePath) IsChildOf(parent ResourcePath) bool {
 	return pp != cp && strings.HasPrefix(cp, pp)
 }
 
+// isInPath reports whether 'rp' is 'path', or is inside the directory at
+// 'path'. Unlike IsChildOf, it only matches whole path components, so
+// "foobar" is not in "foo".
+func (rp ResourcePath) isInPath(path ResourcePath) bool {
+	sep := string(filepath.Separator)
+	dir := strings.TrimRight(string(path), sep)
+	return string(rp) == dir || strings.HasPrefix(string(rp), dir+sep)
+}
+
 var markOptionsDefault = &MarkOptions{}
 
 type MarkOptions struct {
 	Force  bool // Ignore 'no change' error
 	Status *ProjectStatus
+
+	// Used to calculate the status if Status is nil
+	StatusOptions *StatusOptions
+}
+
+var statusOptionsDefault = &StatusOptions{}
+
+type StatusOptions struct {
+	// Ignore the hash cache and re-hash every file. The cache is still updated
+	// with the new hashes.
+	Paranoid bool
+
+	// Number of files to hash concurrently. Uses GOMAXPROCS if <= 0.
+	Workers int
 }
 
 type Project interface {
@@ -32,8 +55,8 @@ type Project interface {
 	Path() string
 	Kind() ProjectKind
 	LastEntry() (*LogEntry, error)
-	Status(ctx context.Context, path ResourcePath, at time.Time) (*ProjectStatus, error)
-	Diff(ctx context.Context, path ResourcePath, at time.Time) (*ProjectDiff, error)
+	Status(ctx context.Context, path ResourcePath, at time.Time, options *StatusOptions) (*ProjectStatus, error)
+	Diff(ctx context.Context, path ResourcePath, at time.Time, options *StatusOptions) (*ProjectDiff, error)
 	Mark(ctx context.Context, session *Session, message string, at time.Time, options *MarkOptions) (*ProjectStatus, error)
 	Log() LogIterator
 	Tagger() Tagger
@@ -2,7 +2,9 @@ package prj
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type ProjectKind int
@@ -11,87 +13,239 @@ const (
 	ProjectSimple ProjectKind = iota + 1
 	ProjectGit
 	ProjectHg
+	ProjectSvn
+	ProjectFossil
+	ProjectBzr
+	ProjectJJ
+)
+
+// ContainsFunc reports whether 'dir' is the root of a project. 'dir' is
+// always absolute.
+type ContainsFunc func(dir string) (bool, error)
+
+// LoadFunc loads the project rooted at 'path', which ContainsFunc has
+// already reported is a project. 'path' is always absolute.
+type LoadFunc func(path string) (Project, error)
+
+type projectKindInfo struct {
+	name     string
+	contains ContainsFunc
+	load     LoadFunc
 
-	ProjectKindSize int = iota + 1
+	// Names of directories that hold the project's metadata, which Scan
+	// never descends into.
+	skipDirs []string
+}
+
+// KindOption configures a kind of project added with RegisterKind.
+type KindOption func(info *projectKindInfo)
+
+// KindSkipDir names directories that hold the kind's metadata (i.e. ".git"),
+// wherever they are found. Scan never descends into them.
+func KindSkipDir(names ...string) KindOption {
+	return func(info *projectKindInfo) {
+		info.skipDirs = append(info.skipDirs, names...)
+	}
+}
+
+var (
+	projectKindsLock sync.RWMutex
+
+	// Indexed by ProjectKind; the zero kind is invalid. When a directory
+	// contains more than one kind of project, the kind that was registered
+	// first wins.
+	projectKinds = []projectKindInfo{
+		{},
+		ProjectSimple: {"prj", containsSimpleProjectUnchecked, func(path string) (Project, error) { return loadKind(LoadSimpleProject(path)) }, []string{ProjectPath}},
+		ProjectGit:    {"git", containsGitProjectUnchecked, func(path string) (Project, error) { return loadKind(LoadGitProject(path)) }, []string{".git"}},
+		ProjectHg:     {"hg", containsHgProjectUnchecked, func(path string) (Project, error) { return loadKind(LoadHgProject(path)) }, []string{".hg"}},
+		ProjectSvn:    {"svn", containsSvnProjectUnchecked, func(path string) (Project, error) { return loadKind(LoadSvnProject(path)) }, []string{".svn"}},
+		ProjectFossil: {"fossil", containsFossilProjectUnchecked, func(path string) (Project, error) { return loadKind(LoadFossilProject(path)) }, nil},
+		ProjectBzr:    {"bzr", containsBzrProjectUnchecked, func(path string) (Project, error) { return loadKind(LoadBzrProject(path)) }, []string{".bzr"}},
+		ProjectJJ:     {"jj", containsJJProjectUnchecked, func(path string) (Project, error) { return loadKind(LoadJJProject(path)) }, []string{".jj"}},
+	}
 )
 
+// loadKind stops a nil *SimpleProject (etc) from becoming a non-nil Project.
+func loadKind(project Project, err error) (Project, error) {
+	if err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// RegisterKind adds a kind of project, so it can be found by Scan and
+// FindRoot, named in a ProjectKindSet, and so on. Kinds are checked in the
+// order they were registered, after the built-in kinds. If the kind keeps its
+// metadata in a directory, pass KindSkipDir so Scan doesn't walk into it.
+//
+// It is intended to be called from an init function; it panics if 'name' is
+// empty or already registered.
+func RegisterKind(name string, contains ContainsFunc, load LoadFunc, options ...KindOption) ProjectKind {
+	if name == "" || contains == nil || load == nil {
+		panic("prj: RegisterKind requires a name, contains and load")
+	}
+
+	info := projectKindInfo{name: name, contains: contains, load: load}
+	for _, o := range options {
+		o(&info)
+	}
+
+	projectKindsLock.Lock()
+	defer projectKindsLock.Unlock()
+
+	for _, info := range projectKinds {
+		if info.name == name {
+			panic(fmt.Errorf("prj: project kind %q is already registered", name))
+		}
+	}
+	projectKinds = append(projectKinds, info)
+	return ProjectKind(len(projectKinds) - 1)
+}
+
+// isKindSkipDir reports whether 'name' is a directory that holds a
+// registered kind's metadata; see KindSkipDir.
+func isKindSkipDir(name string) bool {
+	projectKindsLock.RLock()
+	defer projectKindsLock.RUnlock()
+
+	for _, info := range projectKinds {
+		for _, dir := range info.skipDirs {
+			if dir == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ProjectKinds returns every registered kind, in the order they are checked.
+func ProjectKinds() []ProjectKind {
+	projectKindsLock.RLock()
+	defer projectKindsLock.RUnlock()
+
+	kinds := make([]ProjectKind, 0, len(projectKinds)-1)
+	for i := 1; i < len(projectKinds); i++ {
+		kinds = append(kinds, ProjectKind(i))
+	}
+	return kinds
+}
+
+func (k ProjectKind) info() (info projectKindInfo, ok bool) {
+	projectKindsLock.RLock()
+	defer projectKindsLock.RUnlock()
+
+	if k <= 0 || int(k) >= len(projectKinds) {
+		return info, false
+	}
+	return projectKinds[k], true
+}
+
 func (k ProjectKind) Load(path string) (Project, error) {
-	switch k {
-	case ProjectSimple:
-		return LoadSimpleProject(path)
-	case ProjectGit:
-		return LoadGitProject(path)
-	case ProjectHg:
-		return LoadHgProject(path)
-	default:
-		panic("unsupported contains")
+	info, ok := k.info()
+	if !ok {
+		return nil, fmt.Errorf("prj: unknown project kind %d", k)
 	}
+	return info.load(path)
 }
 
 func (k ProjectKind) Contains(path string) (bool, error) {
-	switch k {
-	case ProjectSimple:
-		return ContainsSimpleProject(path)
-	case ProjectGit:
-		return ContainsGitProject(path)
-	case ProjectHg:
-		return ContainsHgProject(path)
-	default:
-		panic("unsupported contains")
+	info, ok := k.info()
+	if !ok {
+		return false, fmt.Errorf("prj: unknown project kind %d", k)
+	}
+	if !filepath.IsAbs(path) {
+		return false, fmt.Errorf("prj: input %q is not absolute", path)
 	}
+	return info.contains(path)
 }
 
 func (k ProjectKind) String() string {
-	switch k {
-	case ProjectSimple:
-		return "prj"
-	case ProjectGit:
-		return "git"
-	case ProjectHg:
-		return "hg"
-	default:
-		return "unknown"
+	if info, ok := k.info(); ok {
+		return info.name
 	}
+	return "unknown"
 }
 
 func (k ProjectKind) IsValid() bool {
-	switch k {
-	case ProjectSimple,
-		ProjectGit,
-		ProjectHg:
-		return true
-	default:
-		return false
-	}
+	_, ok := k.info()
+	return ok
 }
 
 func (k *ProjectKind) Set(s string) error {
-	switch s {
-	case "prj":
-		*k = ProjectSimple
-	case "git":
-		*k = ProjectGit
-	case "hg":
-		*k = ProjectHg
-	default:
-		return fmt.Errorf("unknown project kind %q", s)
+	projectKindsLock.RLock()
+	defer projectKindsLock.RUnlock()
+
+	for i := 1; i < len(projectKinds); i++ {
+		if projectKinds[i].name == s {
+			*k = ProjectKind(i)
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("unknown project kind %q", s)
 }
 
-type ProjectKindSet [ProjectKindSize]bool
+// ProjectKindsHelp lists the names of the registered kinds, for use in help
+// text.
+func ProjectKindsHelp() string {
+	var names []string
+	for _, kind := range ProjectKinds() {
+		names = append(names, kind.String())
+	}
+	return strings.Join(names, ", ")
+}
 
-func (p *ProjectKindSet) SetAll() {
-	for i := ProjectKind(0); i < ProjectKind(ProjectKindSize); i++ {
-		if i > 0 {
-			p[i] = true
+// detectKind returns the first registered kind of project that 'dir' is the
+// root of. 'dir' must be absolute.
+func detectKind(dir string) (kind ProjectKind, found bool, err error) {
+	return detectKindIn(dir, ProjectKinds())
+}
+
+// detectKindIn returns the first of 'kinds' that 'dir' is the root of.
+// 'dir' must be absolute.
+func detectKindIn(dir string, kinds []ProjectKind) (kind ProjectKind, found bool, err error) {
+	for _, kind := range kinds {
+		info, ok := kind.info()
+		if !ok {
+			return 0, false, fmt.Errorf("prj: unknown project kind %d", kind)
+		}
+		if ok, err := info.contains(dir); err != nil {
+			return 0, false, err
+		} else if ok {
+			return kind, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// detectKinds returns every registered kind of project that 'dir' is the
+// root of. 'dir' must be absolute.
+func detectKinds(dir string) (kinds []ProjectKind, err error) {
+	for _, kind := range ProjectKinds() {
+		info, _ := kind.info()
+		if ok, err := info.contains(dir); err != nil {
+			return nil, err
+		} else if ok {
+			kinds = append(kinds, kind)
 		}
 	}
+	return kinds, nil
 }
 
-func (p *ProjectKindSet) Count() (n int) {
-	for i := 1; i < ProjectKindSize; i++ {
-		if p[ProjectKind(i)] {
+type ProjectKindSet map[ProjectKind]bool
+
+func (p *ProjectKindSet) SetAll() {
+	if *p == nil {
+		*p = ProjectKindSet{}
+	}
+	for _, kind := range ProjectKinds() {
+		(*p)[kind] = true
+	}
+}
+
+func (p ProjectKindSet) Count() (n int) {
+	for _, ok := range p {
+		if ok {
 			n++
 		}
 	}
@@ -103,16 +257,18 @@ func (p *ProjectKindSet) Set(s string) error {
 	if err := k.Set(s); err != nil {
 		return err
 	}
-	p[k] = true
+	if *p == nil {
+		*p = ProjectKindSet{}
+	}
+	(*p)[k] = true
 	return nil
 }
 
-func (p *ProjectKindSet) String() string {
+func (p ProjectKindSet) String() string {
 	var bits []string
-	for i := 0; i < ProjectKindSize; i++ {
-		k := ProjectKind(i)
-		if p[k] {
-			bits = append(bits, k.String())
+	for _, kind := range ProjectKinds() {
+		if p[kind] {
+			bits = append(bits, kind.String())
 		}
 	}
 	return strings.Join(bits, ",")
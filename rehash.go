@@ -0,0 +1,319 @@
+package prj
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+type RehashOptions struct {
+	// Leave marks that can't be migrated in the old algorithm, rather than
+	// failing. The last mark must always be migrated, as Diff and Verify
+	// compare it to the current state of the project.
+	Force bool
+
+	// Used to hash the files currently in the project
+	StatusOptions *StatusOptions
+}
+
+type RehashResult struct {
+	From, To HashAlgorithm
+
+	// Number of log entries whose status was translated to the new algorithm.
+	Migrated int
+
+	// Log entries that could not be migrated, because they refer to file
+	// contents that are no longer present in the project, or they have no
+	// status file. They are left in the log using the old algorithm; this only
+	// happens if RehashOptions.Force is set.
+	Unmigrated []*LogEntry
+}
+
+// Rehash switches the project to a new hash algorithm, and migrates the log.
+//
+// The content of old versions of files is not kept, so history can only be
+// migrated by hashing the files currently in the project with both
+// algorithms, then translating each old hash that has a match. Entries that
+// refer to content that no longer exists can't be translated. Rehash fails
+// if the last entry can't be translated, or if any other entry can't be
+// translated and RehashOptions.Force is not set; nothing is changed if it
+// fails.
+func (s *SimpleProject) Rehash(ctx context.Context, to HashAlgorithm, at time.Time, options *RehashOptions) (rresult *RehashResult, rerr error) {
+	if options == nil {
+		options = &RehashOptions{}
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer errtools.DeferClose(&rerr, closerFunc(unlock))
+
+	if err := s.refreshConfig(); err != nil {
+		return nil, err
+	}
+
+	from := s.config.hashAlgorithm()
+	if !to.CanCreate() {
+		return nil, fmt.Errorf("prj: unsupported hash algorithm %q", to)
+	} else if from == to {
+		return nil, fmt.Errorf("prj: project already uses hash algorithm %q", to)
+	}
+
+	translate, err := s.rehashTranslation(ctx, to, at, options.StatusOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := s.logEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RehashResult{From: from, To: to}
+
+	// Translate everything before writing anything, so a rehash that can't
+	// be completed leaves the project as it was:
+	statuses := make([]*ProjectStatus, len(entries))
+	for i, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		status, ok, err := s.rehashEntry(entry, translate, to)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			result.Unmigrated = append(result.Unmigrated, entry)
+			continue
+		}
+		statuses[i] = status
+	}
+
+	if len(entries) > 0 && statuses[len(entries)-1] == nil {
+		last := entries[len(entries)-1]
+		if last.StatusFile == "" {
+			return nil, fmt.Errorf("prj: the last mark has no status file, so it can't be migrated; run 'prj mark' first")
+		}
+		return nil, fmt.Errorf("prj: the last mark refers to files that have changed since it was made, so it can't be migrated; run 'prj mark' first")
+	} else if len(result.Unmigrated) > 0 && !options.Force {
+		return nil, fmt.Errorf("prj: %d mark(s) refer to file contents that no longer exist in the project, so can't be migrated; use -force to leave them as they are", len(result.Unmigrated))
+	}
+
+	// Status file names include the hash, so two marks only share one if
+	// they have the same contents; anything else would replace one mark's
+	// status with another's:
+	names := make(map[string]*LogEntry, len(entries))
+	for _, entry := range entries {
+		if entry.StatusFile != "" {
+			names[entry.StatusFile] = entry
+		}
+	}
+	for i, entry := range entries {
+		status := statuses[i]
+		if status == nil {
+			continue
+		}
+		name := statusFileName(status.ModTime, status.Hash)
+		if other := names[name]; other != nil && other != entry && other.Hash.String() != status.Hash.String() {
+			return nil, fmt.Errorf("prj: marks from %s and %s would both use status file %q, so can't be migrated", other.Time, entry.Time, name)
+		}
+		names[name] = &LogEntry{Time: entry.Time, Hash: status.Hash}
+	}
+
+	// Objects go first, as in Mark, so a migrated mark never refers to
+	// content that can't be restored:
+	if err := s.rehashObjects(ctx, translate); err != nil {
+		return nil, err
+	}
+
+	statusPath, err := s.ensureStatusPath()
+	if err != nil {
+		return nil, err
+	}
+
+	var obsolete []string
+	for i, entry := range entries {
+		status := statuses[i]
+		if status == nil {
+			continue
+		}
+
+		statusData, err := encodeStatus(status)
+		if err != nil {
+			return nil, err
+		}
+
+		oldStatusFile := entry.StatusFile
+		entry.Hash = status.Hash
+		entry.StatusFile = statusFileName(status.ModTime, status.Hash)
+		if err := writeFileAtomic(filepath.Join(statusPath, entry.StatusFile), statusData, 0600); err != nil {
+			return nil, err
+		}
+		if oldStatusFile != entry.StatusFile {
+			obsolete = append(obsolete, oldStatusFile)
+		}
+		result.Migrated++
+	}
+
+	{ // Replace the log
+		var buf bytes.Buffer
+		for _, entry := range entries {
+			bts, err := json.Marshal(entry)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(bts)
+			buf.WriteByte('\n')
+		}
+
+		if err := writeFileAtomic(s.logFile(), buf.Bytes(), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	{ // Update config
+		s.config.HashAlgorithm = to
+		if len(entries) > 0 {
+			s.config.LastEntry = entries[len(entries)-1]
+		}
+		if err := s.saveConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only remove the old status files once nothing refers to them:
+	referenced := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		referenced[entry.StatusFile] = true
+	}
+	for _, file := range obsolete {
+		if referenced[file] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(statusPath, file)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// rehashTranslation hashes every file currently in the project with both the
+// old and new algorithm, and returns a map from the old hash to the new.
+func (s *SimpleProject) rehashTranslation(ctx context.Context, to HashAlgorithm, at time.Time, options *StatusOptions) (map[string]Hash, error) {
+	current, err := s.Status(ctx, "", at, options)
+	if err != nil {
+		return nil, err
+	}
+
+	translate := make(map[string]Hash, len(current.Files))
+	for _, file := range current.Files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if file.SubProject != nil {
+			continue // The sub-project's own hash; there's no file to hash.
+		}
+
+		oldHash, newHash, err := hashFileTwice(filepath.Join(s.dataRoot, string(file.Name)), file.Hash.Algorithm, to)
+		if err != nil {
+			return nil, err
+		}
+
+		// If the file changed since Status hashed it, we can't be sure which
+		// version the new hash belongs to:
+		if eq, err := oldHash.Equal(file.Hash); err != nil {
+			return nil, err
+		} else if !eq {
+			return nil, fmt.Errorf("prj: file %q changed while rehashing", file.Name)
+		}
+
+		translate[oldHash.String()] = newHash
+	}
+
+	return translate, nil
+}
+
+// rehashObjects makes every object in the project's object store that has a
+// translation available under its new hash too. The objects under the old
+// hashes are kept, as marks that weren't migrated may still refer to them,
+// and the store may be shared with other projects.
+func (s *SimpleProject) rehashObjects(ctx context.Context, translate map[string]Hash) error {
+	store, err := s.ObjectStore()
+	if err != nil || store == nil {
+		return err
+	}
+
+	for old, newHash := range translate {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		oldHash, err := ParseHash(old)
+		if err != nil {
+			return err
+		}
+		if _, err := store.Link(ctx, oldHash, newHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SimpleProject) rehashEntry(entry *LogEntry, translate map[string]Hash, to HashAlgorithm) (status *ProjectStatus, ok bool, err error) {
+	if entry.StatusFile == "" {
+		return nil, false, nil
+	}
+
+	old, err := s.readStatusFile(entry.StatusFile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	files := make([]ProjectFile, len(old.Files))
+	for i, file := range old.Files {
+		if file.SubProject != nil {
+			// Comes from the sub-project's log, so it stays as it is:
+			files[i] = file
+			continue
+		}
+		newHash, found := translate[file.Hash.String()]
+		if !found {
+			return nil, false, nil
+		}
+		files[i] = file
+		files[i].Hash = newHash
+	}
+
+	return NewProjectStatusWithAlgorithm(files, entry.Time, to), true, nil
+}
+
+func hashFileTwice(file string, a, b HashAlgorithm) (ah, bh Hash, rerr error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return ah, bh, err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	ahasher, err := a.CreateHasher()
+	if err != nil {
+		return ah, bh, err
+	}
+	bhasher, err := b.CreateHasher()
+	if err != nil {
+		return ah, bh, err
+	}
+
+	if _, err := io.Copy(io.MultiWriter(ahasher, bhasher), f); err != nil {
+		return ah, bh, err
+	}
+
+	return a.Sum(ahasher, nil), b.Sum(bhasher, nil), nil
+}
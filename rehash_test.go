@@ -0,0 +1,66 @@
+package prj
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRehashSameModTime(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := at.Add(-time.Hour)
+
+	proj := newTestProject(t, map[string]string{"a": "x", "b": "y", "c": "x"}, at)
+	for _, name := range []string{"a", "c"} {
+		if err := os.Chtimes(filepath.Join(proj.dataRoot, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := proj.Mark(ctx, testSession, "older", at, &MarkOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A change to a file that isn't the newest leaves the ModTime alone,
+	// and uses only content that's still in the project, so both marks can
+	// be migrated:
+	writeTestFile(t, filepath.Join(proj.dataRoot, "a"), "y")
+	if err := os.Chtimes(filepath.Join(proj.dataRoot, "a"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proj.Mark(ctx, testSession, "newer", at.Add(time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algo := range []HashAlgorithm{HashBLAKE2b, HashSHA256} {
+		result, err := proj.Rehash(ctx, algo, at, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+		if len(result.Unmigrated) != 0 {
+			t.Fatalf("%s: expected all marks migrated, found %d unmigrated", algo, len(result.Unmigrated))
+		}
+
+		entries, err := proj.logEntries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		older, newer := entries[1], entries[2]
+		if !older.ModTime.Equal(newer.ModTime) {
+			t.Fatalf("%s: expected the same ModTime, found %s and %s", algo, older.ModTime, newer.ModTime)
+		}
+		if older.StatusFile == newer.StatusFile {
+			t.Fatalf("%s: expected different status files, found %q", algo, older.StatusFile)
+		}
+
+		fsck, err := proj.Fsck(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fsck.Problems) != 0 {
+			t.Fatalf("%s: expected no problems after rehash, found %v", algo, fsck.Problems)
+		}
+	}
+}
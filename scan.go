@@ -2,22 +2,36 @@ package prj
 
 import (
 	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/karrick/godirwalk"
 )
 
 type FoundProject struct {
 	Path    string
+	Kind    ProjectKind
 	Project Project
 	Err     error
+
+	// Set if the directory isn't a project, but looks like it could be one.
+	// Kind and Project are not set. Only reported when using ScanSuggest.
+	Candidate *ProjectCandidate
 }
 
 type ScanOption func(scn *scanConfig) error
 
 type scanConfig struct {
 	nested          bool
+	detectOnly      bool
+	suggest         bool
+	kindOrder       []ProjectKind
+	dirCache        *ScanDirCache
 	excludePatterns []*regexp.Regexp
 }
 
@@ -28,6 +42,56 @@ func ScanNested() ScanOption {
 	}
 }
 
+// ScanDetectOnly reports where projects are, and what kind they are, without
+// loading them. FoundProject.Project is always nil; the caller can use
+// FoundProject.Kind.Load if it needs the project.
+func ScanDetectOnly() ScanOption {
+	return func(scn *scanConfig) error {
+		scn.detectOnly = true
+		return nil
+	}
+}
+
+// ScanSuggest also reports directories that aren't projects, but look like
+// they could be, such as a folder containing a DAW session or a Cargo.toml.
+// See ProjectCandidate.
+//
+// Unlike projects, the scan descends into candidates, so projects inside
+// them are still found. Candidates inside candidates are only reported if
+// ScanNested is also used.
+func ScanSuggest() ScanOption {
+	return func(scn *scanConfig) error {
+		scn.suggest = true
+		return nil
+	}
+}
+
+// ScanWithPriority decides which kind of project is reported when a directory
+// contains more than one. Scan checks each directory on its way down, so it
+// finds the nearest project whatever the RootPriority.Mode; only the order of
+// the kinds matters.
+func ScanWithPriority(priority RootPriority) ScanOption {
+	return func(scn *scanConfig) error {
+		for _, kind := range priority.Kinds {
+			if !kind.IsValid() {
+				return fmt.Errorf("prj: unknown project kind %d", kind)
+			}
+		}
+		scn.kindOrder = priority.order()
+		return nil
+	}
+}
+
+// ScanWithDirCache avoids reading directories that have not changed since
+// the cache was last used. The cache is updated as the scan progresses, and
+// must not be used by anything else until the Scanner is closed.
+func ScanWithDirCache(cache *ScanDirCache) ScanOption {
+	return func(scn *scanConfig) error {
+		scn.dirCache = cache
+		return nil
+	}
+}
+
 func ScanExcludePattern(patterns ...string) ScanOption {
 	return func(scn *scanConfig) error {
 		for _, ptn := range patterns {
@@ -53,82 +117,58 @@ func Scan(path string, opts ...ScanOption) *Scanner {
 	var errc = make(chan error, 1)
 	var stop = make(chan struct{})
 	var errStop = errors.New("stop")
+	var visitor = &scanVisitor{
+		config: &config,
+		root:   path,
+		ignore: newIgnoreMatcher(path, IgnoreFile),
+	}
+
+	emit := func(found *FoundProject) error {
+		select {
+		case result <- found:
+			return nil
+		case <-stop:
+			return errStop
+		}
+	}
 
 	go func() {
 		defer close(result)
 		defer close(errc)
 
-		err := godirwalk.Walk(path, &godirwalk.Options{
-			Unsorted:            true,
-			FollowSymbolicLinks: false,
-			ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
-				// Skipping incoming errors; we actually don't care when scanning
-				// if we can't traverse. The only thing seen so far here is
-				// permissions errors while scanning from root; perhaps we should
-				// log though.
-				return godirwalk.SkipNode
-			},
-			Callback: func(path string, info *godirwalk.Dirent) error {
-				if !info.IsDir() {
-					return nil
-				}
+		var err error
+		if config.dirCache != nil {
+			err = visitor.walkCached(path, config.dirCache, emit)
 
-				if len(config.excludePatterns) > 0 {
-					exp := filepath.ToSlash(path)
-					for _, ptn := range config.excludePatterns {
-						if ptn.MatchString(exp) {
-							return filepath.SkipDir
-						}
-					}
-				}
-
-				// We recurse into projects to look for child projects, so
-				// let's explicitly omit config directories, which we don't
-				// want to recurse into:
-				if _, dir := filepath.Split(path); false ||
-					dir == ".git" ||
-					dir == ".hg" ||
-					dir == ".prj" ||
-					dir == ".svn" {
-					return nil
-				}
-
-				var proj Project
-				var err error
-				if ok, _ := containsSimpleProjectUnchecked(path); ok {
-					proj, err = LoadSimpleProject(path)
-
-				} else if ok, _ := containsGitProjectUnchecked(path); ok {
-					proj, err = LoadGitProject(path)
-
-				} else if ok, _ := containsHgProjectUnchecked(path); ok {
-					proj, err = LoadHgProject(path)
-				}
-
-				if errors.Is(err, ErrProjectNotFound) {
-					// If the Load functions report "project not found", carry on. This
-					// may happen if a .git repo has been initialised but does not have
-					// its first commit, which we rely on to get the repo ID.
-					proj, err = nil, nil
-				}
-
-				if proj != nil || err != nil {
-					found := &FoundProject{Path: path, Project: proj, Err: err}
-
-					select {
-					case result <- found:
-					case <-stop:
-						return errStop
+		} else {
+			err = godirwalk.Walk(path, &godirwalk.Options{
+				Unsorted:            true,
+				FollowSymbolicLinks: false,
+				ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+					// Skipping incoming errors; we actually don't care when scanning
+					// if we can't traverse. The only thing seen so far here is
+					// permissions errors while scanning from root; perhaps we should
+					// log though.
+					return godirwalk.SkipNode
+				},
+				Callback: func(path string, info *godirwalk.Dirent) error {
+					if !info.IsDir() {
+						return nil
 					}
 
-					if proj != nil && !config.nested {
+					found, descend := visitor.visit(path)
+					if found != nil {
+						if err := emit(found); err != nil {
+							return err
+						}
+					}
+					if !descend {
 						return filepath.SkipDir
 					}
-				}
-
-				return nil
-			},
-		})
+					return nil
+				},
+			})
+		}
 
 		if err != nil && err != errStop {
 			errc <- err
@@ -138,6 +178,264 @@ func Scan(path string, opts ...ScanOption) *Scanner {
 	return &Scanner{result: result, errc: errc, stop: stop}
 }
 
+type scanVisitor struct {
+	config *scanConfig
+	root   string
+	ignore *ignoreMatcher
+
+	// The last candidate that was reported. The walk is depth-first, so any
+	// directory inside it is visited before anything outside it.
+	candidate string
+}
+
+// visit checks a single directory for a project, and reports whether the
+// scan should descend into it.
+func (sv *scanVisitor) visit(path string) (found *FoundProject, descend bool) {
+	if sv.skip(path) {
+		return nil, false
+	}
+	kind, ok, _ := detectKindIn(path, sv.kindOrder())
+	return sv.visitKind(path, kind, ok)
+}
+
+// skip reports whether 'path' is excluded or ignored, or is a config
+// directory, none of which are checked for projects or descended into.
+func (sv *scanVisitor) skip(path string) bool {
+	if len(sv.config.excludePatterns) > 0 {
+		exp := filepath.ToSlash(path)
+		for _, ptn := range sv.config.excludePatterns {
+			if ptn.MatchString(exp) {
+				return true
+			}
+		}
+	}
+
+	if rel, err := filepath.Rel(sv.root, path); err == nil {
+		if parts := splitResourcePath(rel); len(parts) > 0 {
+			if ignored, _ := sv.ignore.Match(parts, true); ignored {
+				return true
+			}
+		}
+	}
+
+	// We recurse into projects to look for child projects, so
+	// let's explicitly omit config directories, which we don't
+	// want to recurse into:
+	_, dir := filepath.Split(path)
+	return isKindSkipDir(dir)
+}
+
+func (sv *scanVisitor) kindOrder() []ProjectKind {
+	if sv.config.kindOrder == nil {
+		return ProjectKinds()
+	}
+	return sv.config.kindOrder
+}
+
+// visitKind reports the project of 'kind' at 'path', if 'ok', and whether
+// the scan should descend into it.
+func (sv *scanVisitor) visitKind(path string, kind ProjectKind, ok bool) (found *FoundProject, descend bool) {
+	if !ok {
+		if sv.config.suggest {
+			return sv.visitCandidate(path), true
+		}
+		return nil, true
+	}
+
+	if sv.config.detectOnly {
+		return &FoundProject{Path: path, Kind: kind}, sv.config.nested
+	}
+
+	proj, err := kind.Load(path)
+	found = &FoundProject{Path: path, Kind: kind, Project: proj, Err: err}
+	return found, proj == nil || sv.config.nested
+}
+
+func (sv *scanVisitor) visitCandidate(path string) (found *FoundProject) {
+	if sv.candidate != "" && !sv.config.nested &&
+		strings.HasPrefix(path, sv.candidate+string(filepath.Separator)) {
+		return nil
+	}
+
+	candidate, err := detectCandidate(path)
+	if err != nil || candidate == nil {
+		return nil
+	}
+
+	sv.candidate = path
+	return &FoundProject{Path: path, Candidate: candidate}
+}
+
+// walkCached is the equivalent of godirwalk.Walk that uses a ScanDirCache
+// to avoid reading directories, or checking them for projects, if they have
+// not changed.
+func (sv *scanVisitor) walkCached(path string, cache *ScanDirCache, emit func(found *FoundProject) error) error {
+	info, err := os.Lstat(path)
+	if err != nil || !info.IsDir() {
+		return nil // Skipped, as with the ErrorCallback in Scan
+	}
+	if sv.skip(path) {
+		return nil
+	}
+
+	dir, err := cache.dir(path, info.ModTime())
+	if dir == nil {
+		return nil
+	}
+
+	kind, ok := dir.kind(sv.kindOrder())
+	found, descend := sv.visitKind(path, kind, ok)
+	if found != nil {
+		if err := emit(found); err != nil {
+			return err
+		}
+	}
+	if !descend || err != nil {
+		return nil
+	}
+
+	for _, sub := range dir.Subdirs {
+		if err := sv.walkCached(filepath.Join(path, sub), cache, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanDirCache remembers the subdirectories of each directory visited by
+// Scan, and which kinds of project the directory is the root of, along with
+// the directory's modification time. A directory's mtime changes when entries
+// are added to, removed from or renamed within it, which includes the files
+// and directories that mark a project ('.git', '.prj' and so on), so an
+// unchanged directory can be descended into without reading or checking it
+// again.
+//
+// ScanDirCache can be serialised as JSON.
+type ScanDirCache struct {
+	Dirs map[string]*ScanCachedDir
+
+	// Names of the kinds of project each directory was checked for. If kinds
+	// are registered or removed, the cached kinds can't be trusted, so the
+	// cache is emptied.
+	Kinds []string `json:",omitempty"`
+
+	// Number of directories that were, or were not, found in the cache.
+	Hits, Misses int `json:"-"`
+
+	visited     map[string]bool
+	kindsLoaded bool
+}
+
+type ScanCachedDir struct {
+	ModTime time.Time
+	Subdirs []string `json:",omitempty"`
+
+	// Names of the kinds of project the directory is the root of.
+	Kinds []string `json:",omitempty"`
+}
+
+// kind returns the first of 'order' that the directory is the root of.
+func (d *ScanCachedDir) kind(order []ProjectKind) (kind ProjectKind, ok bool) {
+	for _, kind := range order {
+		for _, name := range d.Kinds {
+			if kind.String() == name {
+				return kind, true
+			}
+		}
+	}
+	return 0, false
+}
+
+const scanDirCacheRacyWindow = 2 * time.Second
+
+func NewScanDirCache() *ScanDirCache {
+	return &ScanDirCache{Dirs: map[string]*ScanCachedDir{}}
+}
+
+// loadKinds empties the cache if it was built with different kinds of
+// project to the ones registered now.
+func (c *ScanDirCache) loadKinds() {
+	if c.kindsLoaded {
+		return
+	}
+	c.kindsLoaded = true
+
+	var names []string
+	for _, kind := range ProjectKinds() {
+		names = append(names, kind.String())
+	}
+
+	same := len(names) == len(c.Kinds)
+	for i := 0; same && i < len(names); i++ {
+		same = names[i] == c.Kinds[i]
+	}
+	if !same {
+		c.Dirs = map[string]*ScanCachedDir{}
+		c.Kinds = names
+	}
+}
+
+// dir returns the cached entry for 'path', or checks and reads the directory
+// if it has changed. If the directory can't be read, the entry is returned
+// without Subdirs along with the error.
+func (c *ScanDirCache) dir(path string, modTime time.Time) (*ScanCachedDir, error) {
+	c.loadKinds()
+	if c.Dirs == nil {
+		c.Dirs = map[string]*ScanCachedDir{}
+	}
+	if c.visited == nil {
+		c.visited = map[string]bool{}
+	}
+	c.visited[path] = true
+
+	if cached := c.Dirs[path]; cached != nil && cached.ModTime.Equal(modTime) {
+		c.Hits++
+		return cached, nil
+	}
+	c.Misses++
+	delete(c.Dirs, path)
+
+	// As with visit, a directory that can't be checked is treated as if it
+	// isn't a project, but it isn't cached, so it is checked again next time:
+	dir := &ScanCachedDir{ModTime: modTime}
+	kinds, kindsErr := detectKinds(path)
+	for _, kind := range kinds {
+		dir.Kinds = append(dir.Kinds, kind.String())
+	}
+
+	dirents, err := godirwalk.ReadDirents(path, nil)
+	if err != nil {
+		return dir, err
+	}
+	for _, de := range dirents {
+		if de.IsDir() {
+			dir.Subdirs = append(dir.Subdirs, de.Name())
+		}
+	}
+	sort.Strings(dir.Subdirs)
+
+	// A directory modified within the mtime resolution of the moment it was
+	// read could change again without its mtime changing, so don't trust it:
+	if kindsErr == nil && modTime.Before(time.Now().Add(-scanDirCacheRacyWindow)) {
+		c.Dirs[path] = dir
+	}
+
+	return dir, nil
+}
+
+// Prune removes directories that were not visited by any Scan since the
+// cache was created or last pruned, i.e. because they no longer exist.
+func (c *ScanDirCache) Prune() (removed int) {
+	for path := range c.Dirs {
+		if !c.visited[path] {
+			delete(c.Dirs, path)
+			removed++
+		}
+	}
+	c.visited = nil
+	return removed
+}
+
 type Scanner struct {
 	result chan *FoundProject
 	errc   chan error
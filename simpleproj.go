@@ -6,9 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/shabbyrobe/golib/errtools"
@@ -52,6 +52,9 @@ func (s *SimpleProject) Name() string      { return s.config.Name }
 func (s *SimpleProject) Path() string      { return s.dataRoot }
 func (s *SimpleProject) Kind() ProjectKind { return ProjectSimple }
 
+// HashAlgorithm is the algorithm used to hash the files in the project.
+func (s *SimpleProject) HashAlgorithm() HashAlgorithm { return s.config.hashAlgorithm() }
+
 func (s *SimpleProject) LastEntry() (*LogEntry, error) {
 	return s.config.LastEntry, nil
 }
@@ -64,6 +67,10 @@ func (s *SimpleProject) configFile() string {
 	return filepath.Join(s.metaRoot, ProjectPath, ProjectConfigFile)
 }
 
+func (s *SimpleProject) hashCacheFile() string {
+	return hashCacheFileFromDir(s.metaRoot)
+}
+
 func (s *SimpleProject) statusPath() string {
 	return filepath.Join(s.metaRoot, ProjectPath, projectStatusPath)
 }
@@ -85,17 +92,11 @@ func (s *SimpleProject) ensureStatusPath() (string, error) {
 }
 
 func (s *SimpleProject) saveConfig() error {
-	tmpFile := s.configFile() + ".tmp"
-
 	bts, err := json.MarshalIndent(s.config, "", "  ")
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(tmpFile, bts, 0600); err != nil {
-		return err
-	}
-
-	return os.Rename(tmpFile, s.configFile())
+	return writeFileAtomic(s.configFile(), bts, 0600)
 }
 
 func (s *SimpleProject) refreshConfig() (err error) {
@@ -124,7 +125,12 @@ func (s *SimpleProject) Mark(ctx context.Context, session *Session, message stri
 		options = markOptionsDefault
 	}
 
-	// FIXME: flock
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer errtools.DeferClose(&rerr, closerFunc(unlock))
+
 	if err := s.refreshConfig(); err != nil {
 		return nil, err
 	}
@@ -133,7 +139,7 @@ func (s *SimpleProject) Mark(ctx context.Context, session *Session, message stri
 
 	if status == nil {
 		var err error
-		status, err = s.Status(ctx, "", at)
+		status, err = s.Status(ctx, "", at, options.StatusOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -141,7 +147,9 @@ func (s *SimpleProject) Mark(ctx context.Context, session *Session, message stri
 
 	logEntry := status.LogEntry(session, message, at)
 
-	if !options.Force && s.config.LastEntry != nil {
+	// If the algorithm has changed since the last entry, the hashes can't be
+	// compared, so we assume the project has changed:
+	if !options.Force && s.config.LastEntry != nil && s.config.LastEntry.Hash.Algorithm == logEntry.Hash.Algorithm {
 		if ok, err := s.config.LastEntry.Hash.Equal(logEntry.Hash); err != nil {
 			return status, err
 		} else if ok {
@@ -149,13 +157,25 @@ func (s *SimpleProject) Mark(ctx context.Context, session *Session, message stri
 		}
 	}
 
+	// Status file names include the hash, so an existing file with this name
+	// should only ever hold the same status, as when a mark of an unchanged
+	// project is forced. Anything else belongs to another mark:
+	if err := s.checkStatusFileFree(logEntry.StatusFile, status.Hash); err != nil {
+		return nil, err
+	}
+
+	// Objects go first, so a mark never refers to content that isn't there:
+	if err := s.storeObjects(ctx, status); err != nil {
+		return nil, err
+	}
+
 	// Prepare serialised data before writing anything:
 	statusPath, err := s.ensureStatusPath()
 	if err != nil {
 		return nil, err
 	}
 
-	statusData, err := json.MarshalIndent(status, "", "  ")
+	statusData, err := encodeStatus(status)
 	if err != nil {
 		return nil, err
 	}
@@ -166,14 +186,20 @@ func (s *SimpleProject) Mark(ctx context.Context, session *Session, message stri
 	}
 	logEntryData = append(logEntryData, '\n')
 
-	{ // Append to log
-		f, err := os.OpenFile(s.logFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
-		if err != nil {
+	// The writes are ordered so that a crash at any point leaves the project
+	// usable: the status file is written before anything refers to it, and
+	// the config's LastEntry is only updated once the log is. A crash can
+	// leave an unreferenced status file, a partial last line in the log, or
+	// a config that's one entry behind the log; 'prj fsck' repairs these.
+
+	{ // Write status file
+		if err := writeFileAtomic(filepath.Join(statusPath, logEntry.StatusFile), statusData, 0600); err != nil {
 			return nil, err
 		}
-		defer errtools.DeferClose(&rerr, f)
+	}
 
-		if _, err := f.Write(logEntryData); err != nil {
+	{ // Append to log
+		if err := appendFileSync(s.logFile(), logEntryData, 0600); err != nil {
 			return nil, err
 		}
 	}
@@ -185,23 +211,68 @@ func (s *SimpleProject) Mark(ctx context.Context, session *Session, message stri
 		}
 	}
 
-	{ // Write status file
-		if err := ioutil.WriteFile(filepath.Join(statusPath, logEntry.StatusFile), statusData, 0600); err != nil {
-			return nil, err
-		}
+	return status, nil
+}
 
+// checkStatusFileFree returns an error if the status file 'name' exists and
+// holds a status with a hash other than 'hash'.
+func (s *SimpleProject) checkStatusFileFree(name string, hash Hash) error {
+	if _, err := os.Stat(filepath.Join(s.statusPath(), name)); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
 	}
 
-	return status, nil
+	existing, err := s.readStatusFile(name)
+	if err != nil {
+		return fmt.Errorf("prj: status file %q already exists and could not be read: %w; see 'prj fsck'", name, err)
+	}
+	if existing.Hash.String() != hash.String() {
+		return fmt.Errorf("prj: status file %q already exists for a different mark", name)
+	}
+	return nil
 }
 
-func (s *SimpleProject) Status(ctx context.Context, childPath ResourcePath, at time.Time) (*ProjectStatus, error) {
+func (s *SimpleProject) Status(ctx context.Context, childPath ResourcePath, at time.Time, options *StatusOptions) (*ProjectStatus, error) {
+	if options == nil {
+		options = statusOptionsDefault
+	}
+
+	var algo = s.config.hashAlgorithm()
+	var subProjects = s.config.subProjectPolicy()
 	var files []ProjectFile
+	var stats StatusStats
+	var started = time.Now()
+	var cache = loadHashCache(s.hashCacheFile())
+	var seen = map[ResourcePath]struct{}{}
+	var ignore = s.ignoreMatcher()
 
-	if err := filepath.Walk(filepath.Join(s.dataRoot, string(childPath)), func(path string, info os.FileInfo, err error) error {
+	// Guards files, stats and cache, which are shared with the hash workers:
+	var mu sync.Mutex
+
+	addFile := func(job hashJob, hash Hash) {
+		files = append(files, ProjectFile{
+			Name:    job.name,
+			Hash:    hash,
+			ModTime: job.info.ModTime(),
+			Size:    job.info.Size(),
+		})
+	}
+
+	pool := newHashPool(ctx, options.Workers, algo, func(job hashJob, hash Hash) {
+		mu.Lock()
+		defer mu.Unlock()
+		cache.Put(job.name, job.info, hash, started)
+		addFile(job, hash)
+	})
+
+	walkErr := filepath.Walk(filepath.Join(s.dataRoot, string(childPath)), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := pool.Context().Err(); err != nil {
+			return err
+		}
 
 		if info.Mode()&os.ModeSymlink != 0 {
 			return nil
@@ -215,13 +286,42 @@ func (s *SimpleProject) Status(ctx context.Context, childPath ResourcePath, at t
 				return filepath.SkipDir
 			}
 
-			// FIXME: what if the dir contains a sub-project?
-			return nil
-		}
+			rel, err := filepath.Rel(s.dataRoot, path)
+			if err != nil {
+				return err
+			}
+			parts := splitResourcePath(rel)
+			if len(parts) == 0 {
+				return nil
+			}
 
-		hash, err := DefaultHashAlgorithm.HashFile(path)
-		if err != nil {
-			return fmt.Errorf("prj: hash file %q failed: %w", path, err)
+			if ignored, err := ignore.MatchAny(parts, true); err != nil {
+				return err
+			} else if ignored {
+				return filepath.SkipDir
+			}
+
+			if subProjects != SubProjectInclude {
+				kind, found, err := detectKind(path)
+				if err != nil {
+					return err
+				} else if !found {
+					return nil
+				}
+
+				if subProjects == SubProjectOpaque {
+					file, err := opaqueSubProjectFile(path, ResourcePath(rel), kind)
+					if err != nil {
+						return err
+					}
+					mu.Lock()
+					files = append(files, file)
+					mu.Unlock()
+				}
+				return filepath.SkipDir
+			}
+
+			return nil
 		}
 
 		ok, _, left, err := pathtools.FilepathPrefix(path, s.dataRoot)
@@ -231,55 +331,98 @@ func (s *SimpleProject) Status(ctx context.Context, childPath ResourcePath, at t
 			return fmt.Errorf("prj: path %q escaped root %q", path, s.dataRoot)
 		}
 
-		files = append(files, ProjectFile{
-			Name:    ResourcePath(left),
-			Hash:    hash,
-			ModTime: info.ModTime(),
-			Size:    info.Size(),
-		})
+		if ignored, err := ignore.MatchAny(splitResourcePath(left), false); err != nil {
+			return err
+		} else if ignored {
+			return nil
+		}
 
-		return nil
+		job := hashJob{path: path, name: ResourcePath(left), info: info}
+
+		mu.Lock()
+		seen[job.name] = struct{}{}
+		hash, ok := cache.Get(job.name, info, algo)
+		if ok && !options.Paranoid {
+			stats.CacheHits++
+			addFile(job, hash)
+			mu.Unlock()
+			return nil
+		}
+		stats.CacheMisses++
+		mu.Unlock()
 
-	}); err != nil {
+		return pool.Submit(job)
+	})
+
+	// If a worker failed, the walk will have stopped with a less useful
+	// context error, so the pool's error takes precedence:
+	if err := pool.Wait(); err != nil {
 		return nil, err
+	} else if walkErr != nil {
+		return nil, walkErr
 	}
 
-	status := NewProjectStatus(files, at)
+	// The cache is only an optimisation, and the project may be on a
+	// read-only archive drive, so failing to save it is not an error:
+	cache.Prune(childPath, seen)
+	_ = cache.Save()
+
+	status := NewProjectStatusWithAlgorithm(files, at, algo)
+	status.Stats = stats
 
 	return status, nil
 }
 
-func (s *SimpleProject) Diff(ctx context.Context, path ResourcePath, at time.Time) (*ProjectDiff, error) {
-	if err := s.refreshConfig(); err != nil {
-		return nil, err
-	}
+func (s *SimpleProject) Diff(ctx context.Context, path ResourcePath, at time.Time, options *StatusOptions) (*ProjectDiff, error) {
+	return s.DiffMarks(ctx, path, "", "", at, options)
+}
 
-	currentStatus, err := s.Status(ctx, path, at)
+// SetSubProjectPolicy changes how projects nested inside this one are
+// treated by Status. Changing the policy will usually change the project's
+// hash.
+func (s *SimpleProject) SetSubProjectPolicy(policy SubProjectPolicy) (rerr error) {
+	if !policy.IsValid() {
+		return fmt.Errorf("prj: unknown sub-project policy %q", policy)
+	}
+	unlock, err := s.lock()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer errtools.DeferClose(&rerr, closerFunc(unlock))
 
-	var lastStatus ProjectStatus
-	if s.config.LastEntry != nil {
-		if s.config.LastEntry.StatusFile == "" {
-			return nil, fmt.Errorf("prj: no status file for last log entry, cannot diff")
-		}
+	if err := s.refreshConfig(); err != nil {
+		return err
+	}
+	s.config.SubProjects = policy
+	return s.saveConfig()
+}
 
-		bts, err := ioutil.ReadFile(filepath.Join(s.statusPath(), s.config.LastEntry.StatusFile))
-		if err != nil {
-			return nil, fmt.Errorf("prj: could not read status file for last log entry, cannot diff; previous error: %v", err)
-		}
+func (s *SimpleProject) SubProjectPolicy() SubProjectPolicy {
+	return s.config.subProjectPolicy()
+}
 
-		if err := json.Unmarshal(bts, &lastStatus); err != nil {
-			return nil, fmt.Errorf("prj: could not unmarshal status file for last log entry, cannot diff; previous error: %v", err)
-		}
+func (s *SimpleProject) ignoreMatcher() *ignoreMatcher {
+	return newIgnoreMatcher(s.dataRoot, IgnoreFile)
+}
+
+// FilterIgnored returns a copy of status without the files that are
+// currently ignored by the project's IgnoreFile files.
+func (s *SimpleProject) FilterIgnored(status *ProjectStatus, at time.Time) (*ProjectStatus, error) {
+	ignore := s.ignoreMatcher()
 
-		if path != "" {
-			lastStatus = *lastStatus.Filter(path, at)
+	files := make([]ProjectFile, 0, len(status.Files))
+	for _, file := range status.Files {
+		if ignored, err := ignore.MatchAny(splitResourcePath(string(file.Name)), false); err != nil {
+			return nil, err
+		} else if !ignored {
+			files = append(files, file)
 		}
 	}
+	if len(files) == len(status.Files) {
+		return status, nil
+	}
 
-	return currentStatus.CompareTo(&lastStatus)
+	return NewProjectStatusWithAlgorithm(files, at, status.Hash.Algorithm), nil
 }
 
 func (s *SimpleProject) Tagger() Tagger {
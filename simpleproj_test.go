@@ -0,0 +1,95 @@
+package prj
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSession = &Session{User: "test", Machine: "test"}
+
+// newTestProject creates a project in a temp dir holding 'files', and makes
+// its initial mark at 'at'.
+func newTestProject(t *testing.T, files map[string]string, at time.Time, options ...InitOption) *SimpleProject {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, contents := range files {
+		writeTestFile(t, filepath.Join(dir, name), contents)
+	}
+
+	proj, _, err := InitSimpleProject(context.Background(), testSession, dir, "test", at, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proj.(*SimpleProject)
+}
+
+func writeTestFile(t *testing.T, file string, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarkForcedUnchanged(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	proj := newTestProject(t, map[string]string{"foo": "foo"}, at)
+
+	if _, err := proj.Mark(ctx, testSession, "again", at.Add(time.Hour), &MarkOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := proj.logEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].StatusFile != entries[1].StatusFile {
+		t.Fatalf("expected two marks sharing a status file, found %+v", entries)
+	}
+}
+
+func TestMarkStatusFileCollision(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	proj := newTestProject(t, map[string]string{"foo": "foo"}, at)
+
+	initial, err := proj.logEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	initialData, err := ioutil.ReadFile(filepath.Join(proj.statusPath(), initial[0].StatusFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Put another mark's status where the next mark's status would go:
+	writeTestFile(t, filepath.Join(proj.dataRoot, "foo"), "changed")
+	status, err := proj.Status(ctx, "", at, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := filepath.Join(proj.statusPath(), statusFileName(status.ModTime, status.Hash))
+	if err := ioutil.WriteFile(next, initialData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = proj.Mark(ctx, testSession, "next", at.Add(time.Hour), nil)
+	if err == nil || !strings.Contains(err.Error(), "already exists for a different mark") {
+		t.Fatalf("expected collision error, found %v", err)
+	}
+
+	if bts, err := ioutil.ReadFile(next); err != nil || string(bts) != string(initialData) {
+		t.Fatalf("expected existing status file to be left alone: %v", err)
+	}
+	if entries, err := proj.logEntries(); err != nil || len(entries) != 1 {
+		t.Fatalf("expected log to be left alone, found %d entries: %v", len(entries), err)
+	}
+}
@@ -0,0 +1,405 @@
+package prj
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/shabbyrobe/golib/errtools"
+)
+
+// This is a minimal, read-only implementation of the SQLite file format,
+// just enough to read a few rows out of the databases svn and fossil keep
+// their metadata in without shelling out to either. It walks table b-trees
+// (including WITHOUT ROWID tables, which are stored as index b-trees) and
+// decodes records, following overflow pages. It only supports UTF-8
+// databases, and doesn't read the write-ahead log, so changes that haven't
+// been checkpointed yet are not seen.
+//
+// See https://www.sqlite.org/fileformat2.html for the format.
+
+const (
+	sqliteHeaderSize = 100
+	sqliteMagic      = "SQLite format 3\x00"
+	sqliteMaxDepth   = 64 // Deeper than any real b-tree; stops page cycles.
+
+	sqlitePageInteriorIndex = 0x02
+	sqlitePageInteriorTable = 0x05
+	sqlitePageLeafIndex     = 0x0a
+	sqlitePageLeafTable     = 0x0d
+)
+
+var errSqliteStop = errors.New("prj: sqlite scan stopped")
+
+type sqliteDB struct {
+	rdr        io.ReaderAt
+	name       string
+	pageSize   int
+	usableSize int
+	pageCount  uint32
+}
+
+// sqliteRow is a row from a table. Values are nil, int64, float64, string or
+// []byte. Columns declared as INTEGER PRIMARY KEY are an alias for the rowid,
+// and are always nil in Values; use RowID instead. RowID is 0 for WITHOUT
+// ROWID tables.
+type sqliteRow struct {
+	RowID  int64
+	Values []interface{}
+}
+
+// Text returns column 'i' as a string, if it is text or a blob.
+func (row sqliteRow) Text(i int) (string, bool) {
+	if i >= len(row.Values) {
+		return "", false
+	}
+	switch v := row.Values[i].(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+// Int returns column 'i' as an integer, if it is one.
+func (row sqliteRow) Int(i int) (int64, bool) {
+	if i >= len(row.Values) {
+		return 0, false
+	}
+	v, ok := row.Values[i].(int64)
+	return v, ok
+}
+
+// readSqliteFile calls 'fn' for each row in 'table' of the database in 'file',
+// until 'fn' returns false.
+func readSqliteFile(file string, table string, fn func(row sqliteRow) bool) (rerr error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer errtools.DeferClose(&rerr, f)
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	db, err := openSqlite(f, info.Size(), file)
+	if err != nil {
+		return err
+	}
+	return db.ScanTable(table, fn)
+}
+
+func openSqlite(rdr io.ReaderAt, size int64, name string) (*sqliteDB, error) {
+	var hdr [sqliteHeaderSize]byte
+	if _, err := rdr.ReadAt(hdr[:], 0); err == io.EOF {
+		return nil, fmt.Errorf("prj: sqlite database %q is truncated", name)
+	} else if err != nil {
+		return nil, err
+	}
+	if string(hdr[:len(sqliteMagic)]) != sqliteMagic {
+		return nil, fmt.Errorf("prj: %q is not an sqlite database", name)
+	}
+
+	db := &sqliteDB{rdr: rdr, name: name}
+
+	db.pageSize = int(binary.BigEndian.Uint16(hdr[16:18]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, fmt.Errorf("prj: sqlite database %q has invalid page size %d", name, db.pageSize)
+	}
+	db.usableSize = db.pageSize - int(hdr[20])
+	if db.usableSize < 480 {
+		return nil, fmt.Errorf("prj: sqlite database %q has invalid reserved space %d", name, hdr[20])
+	}
+
+	if enc := binary.BigEndian.Uint32(hdr[56:60]); enc > 1 {
+		return nil, fmt.Errorf("prj: sqlite database %q uses unsupported text encoding %d", name, enc)
+	}
+
+	db.pageCount = uint32(size / int64(db.pageSize))
+	return db, nil
+}
+
+// ScanTable calls 'fn' for each row in 'table' until 'fn' returns false.
+// Rows are visited in rowid order, or primary key order for WITHOUT ROWID
+// tables. Table names are not case sensitive, as in SQL.
+func (db *sqliteDB) ScanTable(table string, fn func(row sqliteRow) bool) error {
+	root, err := db.tableRoot(table)
+	if err != nil {
+		return err
+	}
+	err = db.walk(root, 0, fn)
+	if err == errSqliteStop {
+		return nil
+	}
+	return err
+}
+
+// tableRoot finds the root page of 'table' in the schema table, whose rows
+// are (type, name, tbl_name, rootpage, sql).
+func (db *sqliteDB) tableRoot(table string) (root uint32, err error) {
+	err = db.walk(1, 0, func(row sqliteRow) bool {
+		kind, _ := row.Text(0)
+		name, _ := row.Text(1)
+		if kind != "table" || !strings.EqualFold(name, table) {
+			return true
+		}
+		if page, ok := row.Int(3); ok && page > 0 && page <= math.MaxUint32 {
+			root = uint32(page)
+		}
+		return false
+	})
+	if err != nil && err != errSqliteStop {
+		return 0, err
+	}
+	if root == 0 {
+		return 0, fmt.Errorf("prj: table %q not found in sqlite database %q", table, db.name)
+	}
+	return root, nil
+}
+
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	if n < 1 || n > db.pageCount {
+		return nil, fmt.Errorf("prj: sqlite database %q refers to missing page %d", db.name, n)
+	}
+	page := make([]byte, db.pageSize)
+	if _, err := db.rdr.ReadAt(page, int64(n-1)*int64(db.pageSize)); err != nil {
+		return nil, err
+	}
+	return page[:db.usableSize], nil
+}
+
+func (db *sqliteDB) walk(n uint32, depth int, fn func(row sqliteRow) bool) error {
+	if depth > sqliteMaxDepth {
+		return fmt.Errorf("prj: sqlite database %q has a b-tree that is too deep", db.name)
+	}
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+
+	hdr := 0
+	if n == 1 {
+		hdr = sqliteHeaderSize
+	}
+	if hdr+12 > len(page) {
+		return db.corrupt(n)
+	}
+
+	kind := page[hdr]
+	cells := int(binary.BigEndian.Uint16(page[hdr+3:]))
+	interior := kind == sqlitePageInteriorIndex || kind == sqlitePageInteriorTable
+	ptrs := hdr + 8
+	if interior {
+		ptrs = hdr + 12
+	}
+
+	switch kind {
+	case sqlitePageInteriorIndex, sqlitePageInteriorTable, sqlitePageLeafIndex, sqlitePageLeafTable:
+	default:
+		return fmt.Errorf("prj: sqlite database %q page %d has unknown type %#x", db.name, n, kind)
+	}
+	if ptrs+cells*2 > len(page) {
+		return db.corrupt(n)
+	}
+
+	for i := 0; i < cells; i++ {
+		cell := int(binary.BigEndian.Uint16(page[ptrs+i*2:]))
+		if cell >= len(page) {
+			return db.corrupt(n)
+		}
+		data := page[cell:]
+
+		if interior {
+			if len(data) < 4 {
+				return db.corrupt(n)
+			}
+			if err := db.walk(binary.BigEndian.Uint32(data), depth+1, fn); err != nil {
+				return err
+			}
+			data = data[4:]
+			if kind == sqlitePageInteriorTable {
+				continue // Only the key, which is the largest rowid in the child.
+			}
+		}
+
+		size, sn := sqliteVarint(data)
+		if sn == 0 || size < 0 {
+			return db.corrupt(n)
+		}
+		data = data[sn:]
+
+		var row sqliteRow
+		if kind == sqlitePageLeafTable {
+			rowid, rn := sqliteVarint(data)
+			if rn == 0 {
+				return db.corrupt(n)
+			}
+			row.RowID = rowid
+			data = data[rn:]
+		}
+
+		payload, err := db.payload(n, data, size, kind == sqlitePageLeafTable)
+		if err != nil {
+			return err
+		}
+		if row.Values, err = sqliteRecord(payload); err != nil {
+			return fmt.Errorf("prj: sqlite database %q page %d: %w", db.name, n, err)
+		}
+		if !fn(row) {
+			return errSqliteStop
+		}
+	}
+
+	if interior {
+		return db.walk(binary.BigEndian.Uint32(page[hdr+8:]), depth+1, fn)
+	}
+	return nil
+}
+
+// payload reads a cell's payload, which starts at the beginning of 'data'
+// and continues in a chain of overflow pages if it doesn't fit in the page.
+func (db *sqliteDB) payload(n uint32, data []byte, size int64, table bool) ([]byte, error) {
+	usable := int64(db.usableSize)
+
+	maxLocal := usable - 35
+	if !table {
+		maxLocal = (usable-12)*64/255 - 23
+	}
+	if size <= maxLocal {
+		if size > int64(len(data)) {
+			return nil, db.corrupt(n)
+		}
+		return data[:size], nil
+	}
+	if size > int64(db.pageCount)*usable {
+		return nil, db.corrupt(n)
+	}
+
+	minLocal := (usable-12)*32/255 - 23
+	local := minLocal + (size-minLocal)%(usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	if local+4 > int64(len(data)) {
+		return nil, db.corrupt(n)
+	}
+
+	payload := make([]byte, 0, size)
+	payload = append(payload, data[:local]...)
+	next := binary.BigEndian.Uint32(data[local:])
+
+	for pages := uint32(0); int64(len(payload)) < size; pages++ {
+		if next == 0 || pages > db.pageCount {
+			return nil, fmt.Errorf("prj: sqlite database %q has a broken overflow chain from page %d", db.name, n)
+		}
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = binary.BigEndian.Uint32(overflow)
+		chunk := overflow[4:]
+		if remain := size - int64(len(payload)); int64(len(chunk)) > remain {
+			chunk = chunk[:remain]
+		}
+		payload = append(payload, chunk...)
+	}
+
+	return payload, nil
+}
+
+func (db *sqliteDB) corrupt(n uint32) error {
+	return fmt.Errorf("prj: sqlite database %q page %d is corrupt", db.name, n)
+}
+
+// sqliteRecord decodes a record: a header containing the size of the header
+// and the serial type of each column, followed by the values.
+func sqliteRecord(payload []byte) ([]interface{}, error) {
+	hdrSize, n := sqliteVarint(payload)
+	if n == 0 || hdrSize < int64(n) || hdrSize > int64(len(payload)) {
+		return nil, fmt.Errorf("record header is corrupt")
+	}
+	hdr, body := payload[n:hdrSize], payload[hdrSize:]
+
+	var values []interface{}
+	for len(hdr) > 0 {
+		serial, n := sqliteVarint(hdr)
+		if n == 0 || serial < 0 {
+			return nil, fmt.Errorf("record header is corrupt")
+		}
+		hdr = hdr[n:]
+
+		var size int64
+		switch {
+		case serial <= 4:
+			size = serial
+		case serial == 5:
+			size = 6
+		case serial == 6, serial == 7:
+			size = 8
+		case serial == 10, serial == 11:
+			return nil, fmt.Errorf("record uses reserved serial type %d", serial)
+		case serial >= 12:
+			size = (serial - 12) / 2
+		}
+		if size > int64(len(body)) {
+			return nil, fmt.Errorf("record is truncated")
+		}
+		raw := body[:size]
+		body = body[size:]
+
+		switch {
+		case serial == 0:
+			values = append(values, nil)
+		case serial <= 6:
+			var v int64
+			for _, b := range raw {
+				v = v<<8 | int64(b)
+			}
+			// Sign extend from the stored width:
+			shift := uint(64 - 8*len(raw))
+			values = append(values, v<<shift>>shift)
+		case serial == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(raw)))
+		case serial == 8:
+			values = append(values, int64(0))
+		case serial == 9:
+			values = append(values, int64(1))
+		case serial%2 == 0:
+			values = append(values, append([]byte{}, raw...))
+		default:
+			values = append(values, string(raw))
+		}
+	}
+
+	return values, nil
+}
+
+// sqliteVarint decodes a big-endian variable length integer of 1 to 9 bytes.
+// The first 8 bytes contribute 7 bits each, the 9th contributes all 8. n is 0
+// if 'b' is too short.
+func sqliteVarint(b []byte) (v int64, n int) {
+	var u uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			u = u<<8 | uint64(b[i])
+			return int64(u), 9
+		}
+		u = u<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(u), i + 1
+		}
+	}
+	return int64(u), 9
+}
@@ -0,0 +1,210 @@
+package prj
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/fossil/repo.fossil has a WITHOUT ROWID 'config' table with 512
+// byte pages, so it has interior pages and values that overflow. It holds 60
+// rows named 'k000' to 'k059' whose value is 'x' repeated 20 times the row's
+// number, plus 'parent-project-code', 'project-code' and 'zz-int'.
+//
+// testdata/svn/wc.db has a rowid 'NODES' table with 1024 byte pages, and two
+// rows in 'REPOSITORY'.
+
+func TestSqliteScanTable(t *testing.T) {
+	var rows []sqliteRow
+	if err := readSqliteFile(filepath.Join("testdata", "fossil", "repo.fossil"), "CONFIG", func(row sqliteRow) bool {
+		rows = append(rows, row)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 63 {
+		t.Fatalf("expected 63 rows, found %d", len(rows))
+	}
+
+	// Primary key order:
+	for i := 0; i < 60; i++ {
+		name, _ := rows[i].Text(0)
+		value, _ := rows[i].Text(1)
+		mtime, _ := rows[i].Int(2)
+		if name != fmt.Sprintf("k%03d", i) || value != strings.Repeat("x", i*20) || mtime != int64(i) {
+			t.Fatalf("row %d: unexpected row %q (%d byte value), %d", i, name, len(value), mtime)
+		}
+	}
+
+	last := rows[62]
+	if name, _ := last.Text(0); name != "zz-int" {
+		t.Fatalf("unexpected last row %q", name)
+	}
+	if v, ok := last.Text(1); !ok || v != "-123456789012" {
+		t.Fatalf("expected integer stored as text, found %v", last.Values[1])
+	}
+	if v, ok := last.Values[2].(float64); !ok || v != 3.5 {
+		t.Fatalf("expected float, found %v", last.Values[2])
+	}
+}
+
+func TestSqliteScanTableStop(t *testing.T) {
+	var seen int
+	if err := readSqliteFile(filepath.Join("testdata", "svn", "wc.db"), "NODES", func(row sqliteRow) bool {
+		seen++
+		return seen < 5
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if seen != 5 {
+		t.Fatalf("expected scan to stop after 5 rows, found %d", seen)
+	}
+}
+
+func TestSqliteRowID(t *testing.T) {
+	var ids []int64
+	if err := readSqliteFile(filepath.Join("testdata", "svn", "wc.db"), "REPOSITORY", func(row sqliteRow) bool {
+		if row.Values[0] != nil {
+			t.Fatalf("expected INTEGER PRIMARY KEY to be nil, found %v", row.Values[0])
+		}
+		ids = append(ids, row.RowID)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[1 2]" {
+		t.Fatalf("unexpected rowids %v", ids)
+	}
+}
+
+func TestSqliteErrors(t *testing.T) {
+	for idx, tc := range []struct {
+		file  string
+		table string
+		err   string
+	}{
+		{file: filepath.Join("fossil", "repo.fossil"), table: "nope", err: `table "nope" not found`},
+		{file: filepath.Join("sqlite", "utf16.db"), table: "t", err: "unsupported text encoding 2"},
+		{file: filepath.Join("status", "legacy.json"), table: "t", err: "not an sqlite database"},
+		{file: filepath.Join("hg", "truncated.i"), table: "t", err: "truncated"},
+	} {
+		err := readSqliteFile(filepath.Join("testdata", tc.file), tc.table, func(row sqliteRow) bool { return true })
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("%d: expected error containing %q, found %v", idx, tc.err, err)
+		}
+	}
+}
+
+func TestSqliteRecord(t *testing.T) {
+	payload := []byte{
+		14,                           // Header size
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 0, // Integers, float, 0, 1, NULL
+		13 + 2*3, 12 + 2*2, 12, // Text, blob, empty blob
+
+		0xff,
+		0xff, 0xfe,
+		0x01, 0x00, 0x00,
+		0x80, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0xff, 0xff, 0xff, 0xe3, 0x41, 0x66, 0xe5, 0xec,
+		0x40, 0x09, 0x21, 0xf9, 0xf0, 0x1b, 0x86, 0x6e,
+		'a', 'b', 'c',
+		0x00, 0x01,
+	}
+	values, err := sqliteRecord(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		int64(-1), int64(-2), int64(65536), int64(-2147483648), int64(1 << 32), int64(-123456789012),
+		3.14159, int64(0), int64(1), nil, "abc", []byte{0, 1}, []byte{},
+	}
+	if fmt.Sprintf("%#v", values) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("expected %#v, found %#v", expected, values)
+	}
+
+	for idx, bad := range [][]byte{
+		{},
+		{5, 1},       // Header longer than the payload
+		{2, 6, 0x00}, // Value longer than the payload
+		{2, 10},      // Reserved serial type
+	} {
+		if _, err := sqliteRecord(bad); err == nil {
+			t.Fatalf("%d: expected error for %v", idx, bad)
+		}
+	}
+}
+
+func TestSqliteVarint(t *testing.T) {
+	for idx, tc := range []struct {
+		in []byte
+		v  int64
+		n  int
+	}{
+		{in: []byte{0x00}, v: 0, n: 1},
+		{in: []byte{0x7f}, v: 127, n: 1},
+		{in: []byte{0x81, 0x00}, v: 128, n: 2},
+		{in: []byte{0x82, 0x80, 0x01}, v: 1<<15 | 1, n: 3},
+		{in: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, v: -1, n: 9},
+		{in: []byte{0x81}, n: 0},
+		{in: nil, n: 0},
+	} {
+		v, n := sqliteVarint(tc.in)
+		if v != tc.v || n != tc.n {
+			t.Fatalf("%d: expected %d (%d bytes), found %d (%d bytes)", idx, tc.v, tc.n, v, n)
+		}
+	}
+}
+
+func TestSvnReadWCDBID(t *testing.T) {
+	// The working copy was relocated; the root node belongs to the second
+	// repository.
+	id, err := svnReadWCDBID(filepath.Join("testdata", "svn", "wc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "22222222-2222-2222-2222-222222222222" {
+		t.Fatalf("unexpected id %q", id)
+	}
+}
+
+func TestSvnReadEntriesID(t *testing.T) {
+	for idx, tc := range []struct {
+		file string
+		id   string
+	}{
+		{file: "entries", id: "5c2a9d4e-1f3b-4c5d-8e9f-a0b1c2d3e4f5"},
+		{file: "entries-xml", id: "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"},
+	} {
+		id, err := svnReadEntriesID(filepath.Join("testdata", "svn", tc.file))
+		if err != nil {
+			t.Fatalf("%d: %v", idx, err)
+		}
+		if id != tc.id {
+			t.Fatalf("%d: expected %q, found %q", idx, tc.id, id)
+		}
+	}
+}
+
+func TestFossilReadSetting(t *testing.T) {
+	for idx, tc := range []struct {
+		file  string
+		table string
+		name  string
+		value string
+	}{
+		{file: "checkout.fslckout", table: "vvar", name: "repository", value: "repo.fossil"},
+		{file: "repo.fossil", table: "config", name: "project-code", value: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"},
+		{file: "repo.fossil", table: "config", name: "parent-project-code", value: "1111111111111111111111111111111111111111"},
+		{file: "repo.fossil", table: "config", name: "missing", value: ""},
+	} {
+		value, err := fossilReadSetting(filepath.Join("testdata", "fossil", tc.file), tc.table, tc.name)
+		if err != nil {
+			t.Fatalf("%d: %v", idx, err)
+		}
+		if value != tc.value {
+			t.Fatalf("%d: expected %q, found %q", idx, tc.value, value)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package prj
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileInodeCTime extracts the inode and status change time from 'info'.
+func fileInodeCTime(info os.FileInfo) (inode uint64, ctime time.Time) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, ctime
+	}
+	return uint64(st.Ino), time.Unix(int64(st.Ctimespec.Sec), int64(st.Ctimespec.Nsec))
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package prj
+
+import (
+	"os"
+	"time"
+)
+
+// fileInodeCTime is not supported on this platform; the hash cache falls back
+// to size and mtime only.
+func fileInodeCTime(info os.FileInfo) (inode uint64, ctime time.Time) {
+	return 0, ctime
+}
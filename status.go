@@ -15,6 +15,10 @@ type ProjectFile struct {
 	Hash    Hash
 	Size    int64
 	ModTime time.Time
+
+	// Set if this entry stands in for a whole sub-project (see
+	// SubProjectOpaque), in which case Hash is the sub-project's last hash.
+	SubProject *SubProjectRef `json:",omitempty"`
 }
 
 type ProjectStatus struct {
@@ -29,9 +33,27 @@ type ProjectStatus struct {
 	Hash    Hash
 	ModTime time.Time
 	Size    int64
+
+	// Information about how the status was calculated; not persisted.
+	Stats StatusStats `json:"-"`
+}
+
+type StatusStats struct {
+	CacheHits   int
+	CacheMisses int
 }
 
 func NewProjectStatus(files []ProjectFile, at time.Time) *ProjectStatus {
+	return NewProjectStatusWithAlgorithm(files, at, DefaultHashAlgorithm)
+}
+
+// NewProjectStatusWithAlgorithm uses 'algo' to calculate the hash of the
+// whole tree. The files may use a different algorithm to the tree.
+func NewProjectStatusWithAlgorithm(files []ProjectFile, at time.Time, algo HashAlgorithm) *ProjectStatus {
+	if !algo.CanCreate() {
+		algo = DefaultHashAlgorithm
+	}
+
 	ps := &ProjectStatus{
 		Files: files,
 	}
@@ -53,7 +75,7 @@ func NewProjectStatus(files []ProjectFile, at time.Time) *ProjectStatus {
 
 	const projectHashDelimiter = "/"
 
-	hasher, _ := DefaultHashAlgorithm.CreateHasher()
+	hasher, _ := algo.CreateHasher()
 	for _, file := range ps.Files {
 		hasher.Write([]byte(file.Name + projectHashDelimiter))
 		hasher.Write([]byte(file.Hash.Algorithm + projectHashDelimiter))
@@ -61,7 +83,7 @@ func NewProjectStatus(files []ProjectFile, at time.Time) *ProjectStatus {
 		hasher.Write([]byte(projectHashDelimiter))
 	}
 
-	ps.Hash = DefaultHashAlgorithm.Sum(hasher, nil)
+	ps.Hash = algo.Sum(hasher, nil)
 
 	return ps
 }
@@ -75,7 +97,7 @@ func (status *ProjectStatus) Filter(childPath ResourcePath, at time.Time) *Proje
 			files = append(files, file)
 		}
 	}
-	return NewProjectStatus(files, at)
+	return NewProjectStatusWithAlgorithm(files, at, status.Hash.Algorithm)
 }
 
 func (status *ProjectStatus) LogEntry(session *Session, message string, at time.Time) *LogEntry {
@@ -101,16 +123,18 @@ func (status *ProjectStatus) CompareTo(previous *ProjectStatus) (*ProjectDiff, e
 
 	currentFiles := make([]string, len(status.Files))
 	currentIndex := make(map[ResourcePath]*ProjectFile, len(status.Files))
-	for i, f := range status.Files {
+	for i := range status.Files {
+		f := &status.Files[i]
 		currentFiles[i] = string(f.Name)
-		currentIndex[f.Name] = &f
+		currentIndex[f.Name] = f
 	}
 
 	prevFiles := make([]string, len(previous.Files))
 	prevIndex := make(map[ResourcePath]*ProjectFile, len(previous.Files))
-	for i, f := range previous.Files {
+	for i := range previous.Files {
+		f := &previous.Files[i]
 		prevFiles[i] = string(f.Name)
-		prevIndex[f.Name] = &f
+		prevIndex[f.Name] = f
 	}
 
 	sort.Strings(currentFiles)
@@ -160,9 +184,73 @@ func (status *ProjectStatus) CompareTo(previous *ProjectStatus) (*ProjectDiff, e
 		diff.Removed = append(diff.Removed, ResourcePath(prevFiles[i]))
 	}
 
+	diff.detectMoves(currentIndex, prevFiles, prevIndex)
+
 	return &diff, nil
 }
 
+// detectMoves pairs up added files with previous files that have the same
+// content. An added file whose content matches a removed file is a rename; if
+// the content still exists elsewhere in the previous status, it's a copy.
+//
+// Empty files are never paired, as they all share the same hash.
+func (diff *ProjectDiff) detectMoves(currentIndex map[ResourcePath]*ProjectFile, prevFiles []string, prevIndex map[ResourcePath]*ProjectFile) {
+	if len(diff.Added) == 0 {
+		return
+	}
+
+	removed := make(map[string][]ResourcePath, len(diff.Removed))
+	for _, p := range diff.Removed {
+		if f := prevIndex[p]; f.Size > 0 {
+			key := f.Hash.String()
+			removed[key] = append(removed[key], p)
+		}
+	}
+
+	existing := make(map[string]ResourcePath, len(prevFiles))
+	for _, name := range prevFiles { // Sorted, so the first path with each hash wins
+		if f := prevIndex[ResourcePath(name)]; f.Size > 0 {
+			if _, ok := existing[f.Hash.String()]; !ok {
+				existing[f.Hash.String()] = f.Name
+			}
+		}
+	}
+
+	renamedFrom := map[ResourcePath]bool{}
+	added := diff.Added[:0]
+	for _, p := range diff.Added {
+		f := currentIndex[p]
+		if f.Size == 0 {
+			added = append(added, p)
+			continue
+		}
+
+		key := f.Hash.String()
+		if candidates := removed[key]; len(candidates) > 0 {
+			diff.Renamed = append(diff.Renamed, ProjectDiffMove{From: candidates[0], To: p})
+			renamedFrom[candidates[0]] = true
+			removed[key] = candidates[1:]
+
+		} else if from, ok := existing[key]; ok {
+			diff.Copied = append(diff.Copied, ProjectDiffMove{From: from, To: p})
+
+		} else {
+			added = append(added, p)
+		}
+	}
+	diff.Added = added
+
+	if len(renamedFrom) > 0 {
+		remaining := diff.Removed[:0]
+		for _, p := range diff.Removed {
+			if !renamedFrom[p] {
+				remaining = append(remaining, p)
+			}
+		}
+		diff.Removed = remaining
+	}
+}
+
 type ProjectDiff struct {
 	Current  *ProjectStatus
 	Previous *ProjectStatus
@@ -171,15 +259,30 @@ type ProjectDiff struct {
 	Removed  []ResourcePath
 	Modified []ResourcePath
 	Same     []ResourcePath
+
+	// Files that were added with the same content as a file that was removed.
+	Renamed []ProjectDiffMove
+
+	// Files that were added with the same content as a file that is still
+	// present.
+	Copied []ProjectDiffMove
+}
+
+type ProjectDiffMove struct {
+	From ResourcePath
+	To   ResourcePath
 }
 
 type ProjectDiffItem struct {
 	Path   ResourcePath
 	Status DiffStatus
+
+	// Previous path of a renamed or copied file.
+	From ResourcePath
 }
 
 func (diff *ProjectDiff) Items() []ProjectDiffItem {
-	items := make([]ProjectDiffItem, len(diff.Added)+len(diff.Removed)+len(diff.Modified)+len(diff.Same))
+	items := make([]ProjectDiffItem, len(diff.Added)+len(diff.Removed)+len(diff.Modified)+len(diff.Same)+len(diff.Renamed)+len(diff.Copied))
 
 	n := 0
 	for _, p := range diff.Added {
@@ -206,6 +309,20 @@ func (diff *ProjectDiff) Items() []ProjectDiffItem {
 		n++
 	}
 
+	for _, m := range diff.Renamed {
+		items[n].Path = m.To
+		items[n].From = m.From
+		items[n].Status = DiffRenamed
+		n++
+	}
+
+	for _, m := range diff.Copied {
+		items[n].Path = m.To
+		items[n].From = m.From
+		items[n].Status = DiffCopied
+		n++
+	}
+
 	sort.Slice(items, func(i, j int) bool {
 		return items[i].Path < items[j].Path
 	})
@@ -220,10 +337,20 @@ const (
 	DiffRemoved  DiffStatus = 'D'
 	DiffModified DiffStatus = 'M'
 	DiffSame     DiffStatus = '='
+	DiffRenamed  DiffStatus = 'R'
+	DiffCopied   DiffStatus = 'C'
 )
 
+// statusFileName names the status file for a mark from the status's
+// ModTime, its hash algorithm and the start of its hash value.
 func statusFileName(modTime time.Time, hash Hash) string {
-	return fmt.Sprintf("%s-%s.json",
+	value := hex.EncodeToString(hash.Value)
+	if len(value) > 16 {
+		value = value[:16]
+	}
+	return fmt.Sprintf("%s-%s-%s%s",
 		modTime.Format("20060102150405"),
-		hex.EncodeToString([]byte(hash.String()))[:16])
+		hash.Algorithm,
+		value,
+		statusFileCompactExt)
 }
@@ -0,0 +1,70 @@
+package prj
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Status files were originally written as indented JSON, which adds up to
+// gigabytes on big projects with a lot of marks. They are now written as
+// gzipped JSON, with statusFileCompactExt. Both are read, whatever the file
+// is called; 'prj gc' converts the old format.
+const (
+	statusFileExt        = ".json"
+	statusFileCompactExt = ".json.gz"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func encodeStatus(status *ProjectStatus) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(status); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeStatus(bts []byte) (*ProjectStatus, error) {
+	if bytes.HasPrefix(bts, gzipMagic) {
+		gz, err := gzip.NewReader(bytes.NewReader(bts))
+		if err != nil {
+			return nil, err
+		}
+		bts, err = ioutil.ReadAll(gz)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var status ProjectStatus
+	if err := json.Unmarshal(bts, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (s *SimpleProject) readStatusFile(name string) (*ProjectStatus, error) {
+	bts, err := ioutil.ReadFile(filepath.Join(s.statusPath(), name))
+	if err != nil {
+		return nil, err
+	}
+	return decodeStatus(bts)
+}
+
+func isCompactStatusFile(name string) bool {
+	return strings.HasSuffix(name, statusFileCompactExt)
+}
+
+// compactStatusFileName is the name 'name' is given when it is converted to
+// the compact format.
+func compactStatusFileName(name string) string {
+	return strings.TrimSuffix(name, statusFileExt) + statusFileCompactExt
+}
@@ -0,0 +1,121 @@
+package prj
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The fixtures in testdata/status hold the same status: 'legacy.json' in
+// the old indented JSON format, 'compact.json.gz' in the gzipped format.
+const statusFixtureHash = "sha256:GeFCEErKUojGzZ9VtrOnQjL81nlXaW96HdxU2lCboOs="
+
+func TestDecodeStatus(t *testing.T) {
+	for idx, tc := range []struct {
+		file  string
+		files int
+		err   bool
+	}{
+		{file: "legacy.json", files: 3},
+		{file: "compact.json.gz", files: 3},
+		{file: "truncated.json.gz", err: true},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			bts, err := ioutil.ReadFile(filepath.Join("testdata", "status", tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status, err := decodeStatus(bts)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("%d: expected error", idx)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("%d: %v", idx, err)
+			}
+
+			if status.Hash.String() != statusFixtureHash {
+				t.Fatalf("%d: expected hash %s, found %s", idx, statusFixtureHash, status.Hash)
+			}
+			if len(status.Files) != tc.files {
+				t.Fatalf("%d: expected %d files, found %d", idx, tc.files, len(status.Files))
+			}
+			if status.Files[0].Name != "foo.txt" || status.Files[0].Size != 2 {
+				t.Fatalf("%d: unexpected file %+v", idx, status.Files[0])
+			}
+		})
+	}
+}
+
+func TestDecodeStatusInvalid(t *testing.T) {
+	for idx, bts := range [][]byte{
+		nil,
+		[]byte("not json"),
+		gzipMagic,
+		append(append([]byte(nil), gzipMagic...), "not gzip"...),
+	} {
+		if _, err := decodeStatus(bts); err == nil {
+			t.Fatalf("%d: expected error for %q", idx, bts)
+		}
+	}
+}
+
+func TestEncodeStatusRoundTrip(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []ProjectFile{
+		{Name: "a", Hash: Hash{Algorithm: HashSHA256, Value: HashValue{1, 2, 3}}, Size: 1, ModTime: at},
+		{Name: "b/c", Hash: Hash{Algorithm: HashSHA256, Value: HashValue{4, 5, 6}}, Size: 2, ModTime: at},
+	}
+	status := NewProjectStatusWithAlgorithm(files, at, HashSHA256)
+
+	bts, err := encodeStatus(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(bts, gzipMagic) {
+		t.Fatalf("expected gzipped status")
+	}
+
+	decoded, err := decodeStatus(bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eq, err := decoded.Hash.Equal(status.Hash); err != nil || !eq {
+		t.Fatalf("expected hash %s, found %s", status.Hash, decoded.Hash)
+	}
+	if len(decoded.Files) != len(files) || decoded.Files[1].Name != "b/c" {
+		t.Fatalf("unexpected files %+v", decoded.Files)
+	}
+}
+
+func TestStatusFileNameUnique(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for idx, algo := range HashAlgorithms() {
+		hash := Hash{Algorithm: algo, Value: HashValue{1, 2, 3}}
+		files := []ProjectFile{
+			{Name: "a", Hash: hash, Size: 1, ModTime: at.Add(-time.Hour)},
+			{Name: "b", Hash: hash, Size: 1, ModTime: at},
+		}
+
+		// Removing a file that isn't the newest leaves ModTime alone:
+		before := NewProjectStatusWithAlgorithm(files, at, algo)
+		after := NewProjectStatusWithAlgorithm(files[1:], at, algo)
+		if !before.ModTime.Equal(after.ModTime) {
+			t.Fatalf("%d: expected the same ModTime, found %s and %s", idx, before.ModTime, after.ModTime)
+		}
+
+		beforeName := statusFileName(before.ModTime, before.Hash)
+		afterName := statusFileName(after.ModTime, after.Hash)
+		if beforeName == afterName {
+			t.Fatalf("%d: %s: expected different status file names, found %q", idx, algo, beforeName)
+		}
+		if !strings.Contains(beforeName, "-"+string(algo)+"-") {
+			t.Fatalf("%d: expected algorithm in status file name, found %q", idx, beforeName)
+		}
+	}
+}
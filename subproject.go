@@ -0,0 +1,81 @@
+package prj
+
+import (
+	"fmt"
+)
+
+// SubProjectPolicy controls how a simple project's Status treats directories
+// inside it that are themselves projects (i.e. contain a '.prj', '.git' or
+// '.hg' directory).
+type SubProjectPolicy string
+
+const (
+	// Sub-projects are treated like any other directory, and all of their
+	// files (including their VCS metadata) are part of the parent's hash.
+	// This is the default, as it is how projects behaved before the policy
+	// was configurable.
+	SubProjectInclude SubProjectPolicy = "include"
+
+	// Sub-projects are left out of the parent entirely.
+	SubProjectSkip SubProjectPolicy = "skip"
+
+	// Each sub-project appears in the parent as a single entry, named after
+	// its directory, which carries the hash of the sub-project's last entry.
+	// This means a mark in the parent records which version of each child it
+	// contained.
+	SubProjectOpaque SubProjectPolicy = "opaque"
+)
+
+func SubProjectPolicies() []SubProjectPolicy {
+	return []SubProjectPolicy{SubProjectInclude, SubProjectSkip, SubProjectOpaque}
+}
+
+func (p SubProjectPolicy) IsValid() bool {
+	for _, v := range SubProjectPolicies() {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (p SubProjectPolicy) String() string { return string(p) }
+
+func (p *SubProjectPolicy) Set(s string) error {
+	v := SubProjectPolicy(s)
+	if !v.IsValid() {
+		return fmt.Errorf("unknown sub-project policy %q", s)
+	}
+	*p = v
+	return nil
+}
+
+// SubProjectRef identifies the sub-project an opaque ProjectFile refers to.
+type SubProjectRef struct {
+	Kind string
+	ID   string
+}
+
+// opaqueSubProjectFile builds the single entry that stands in for the
+// sub-project at 'dir' when using SubProjectOpaque.
+func opaqueSubProjectFile(dir string, name ResourcePath, kind ProjectKind) (file ProjectFile, err error) {
+	project, err := kind.Load(dir)
+	if err != nil {
+		return file, fmt.Errorf("prj: could not load sub-project %q: %w", name, err)
+	}
+
+	entry, err := project.LastEntry()
+	if err != nil {
+		return file, fmt.Errorf("prj: could not read last entry of sub-project %q: %w", name, err)
+	} else if entry == nil || entry.Hash.IsEmpty() {
+		return file, fmt.Errorf("prj: sub-project %q has no marks or commits, so it can't be included as an opaque entry", name)
+	}
+
+	return ProjectFile{
+		Name:       name,
+		Hash:       entry.Hash,
+		Size:       entry.Size,
+		ModTime:    entry.ModTime,
+		SubProject: &SubProjectRef{Kind: kind.String(), ID: project.ID()},
+	}, nil
+}
@@ -0,0 +1,147 @@
+package prj
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+type SvnProject struct {
+	foreignProject
+}
+
+var _ Project = &SvnProject{}
+
+func ContainsSvnProject(dir string) (ok bool, err error) {
+	if !filepath.IsAbs(dir) {
+		return false, fmt.Errorf("prj: input %q is not absolute", dir)
+	}
+	return containsSvnProjectUnchecked(dir)
+}
+
+// Working copies from svn 1.7 and later only have a '.svn' directory at the
+// root. Older ones have one in every directory, so a directory is only the
+// root if its parent isn't part of the same working copy.
+func containsSvnProjectUnchecked(dir string) (ok bool, err error) {
+	if _, err := os.Stat(filepath.Join(dir, ".svn")); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".svn", "wc.db")); err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	parent := filepath.Dir(dir)
+	if parent == dir {
+		return true, nil
+	}
+	if _, err := os.Stat(filepath.Join(parent, ".svn", "entries")); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+func LoadSvnProject(path string) (*SvnProject, error) {
+	id, err := svnReadID(path)
+	if err != nil {
+		return nil, err
+	}
+	return &SvnProject{foreignProject{path: path, id: id, kind: ProjectSvn}}, nil
+}
+
+const svnUUID = `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`
+
+var (
+	svnUUIDPattern = regexp.MustCompile(`^` + svnUUID + `$`)
+
+	// The old entries file is either line-based, with the UUID on a line of
+	// its own, or XML (svn 1.3 and earlier).
+	svnEntriesUUIDPattern = regexp.MustCompile(`(?m)^(` + svnUUID + `)$|uuid="(` + svnUUID + `)"`)
+)
+
+// Columns used from wc.db. REPOSITORY's 'id' column is the rowid.
+const (
+	svnRepositoryUUID  = 2 // REPOSITORY (id, root, uuid)
+	svnNodesRelPath    = 1 // NODES (wc_id, local_relpath, op_depth, parent_relpath, repos_id, ...)
+	svnNodesOpDepth    = 2
+	svnNodesRepository = 4
+)
+
+// svnReadID uses the UUID of the repository the working copy was checked out
+// from, which is the same for every working copy of the repository.
+func svnReadID(path string) (string, error) {
+	wcdb := filepath.Join(path, ".svn", "wc.db")
+	if _, err := os.Stat(wcdb); os.IsNotExist(err) {
+		return svnReadEntriesID(filepath.Join(path, ".svn", "entries"))
+	} else if err != nil {
+		return "", err
+	}
+	return svnReadWCDBID(wcdb)
+}
+
+// svnReadWCDBID reads the UUID from the wc.db of a working copy from svn 1.7
+// or later.
+func svnReadWCDBID(wcdb string) (string, error) {
+	uuids := map[int64]string{}
+	if err := readSqliteFile(wcdb, "REPOSITORY", func(row sqliteRow) bool {
+		if uuid, ok := row.Text(svnRepositoryUUID); ok {
+			uuids[row.RowID] = uuid
+		}
+		return true
+	}); err != nil {
+		return "", err
+	}
+
+	// A working copy can refer to more than one repository (i.e. after 'svn
+	// switch --relocate'); the one the root node belongs to is the one we
+	// want.
+	var uuid string
+	if len(uuids) == 1 {
+		for _, v := range uuids {
+			uuid = v
+		}
+	} else if len(uuids) > 1 {
+		if err := readSqliteFile(wcdb, "NODES", func(row sqliteRow) bool {
+			relPath, _ := row.Text(svnNodesRelPath)
+			opDepth, _ := row.Int(svnNodesOpDepth)
+			if relPath != "" || opDepth != 0 {
+				return true
+			}
+			if repo, ok := row.Int(svnNodesRepository); ok {
+				uuid = uuids[repo]
+			}
+			return false
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	if uuid == "" {
+		return "", fmt.Errorf("prj: repository UUID not found in %q", wcdb)
+	} else if !svnUUIDPattern.MatchString(uuid) {
+		return "", fmt.Errorf("prj: repository UUID %q in %q is not valid", uuid, wcdb)
+	}
+	return uuid, nil
+}
+
+// svnReadEntriesID reads the UUID from the entries file of a working copy
+// from before svn 1.7.
+func svnReadEntriesID(entries string) (string, error) {
+	match, err := findInFile(entries, svnEntriesUUIDPattern, 4096, nil)
+	if err != nil {
+		return "", err
+	} else if match == nil {
+		return "", fmt.Errorf("prj: repository UUID not found in %q", entries)
+	}
+	if match[1] != nil {
+		return string(match[1]), nil
+	}
+	return string(match[2]), nil
+}
@@ -22,6 +22,13 @@ type fileTagger struct {
 
 var _ Tagger = &fileTagger{}
 
+// TaggerForDir returns the Tagger for the project rooted at 'dir', without
+// having to load the project. All project kinds keep their tags in the same
+// file.
+func TaggerForDir(dir string) Tagger {
+	return fileTaggerFromDir(dir)
+}
+
 func fileTaggerFromDir(dir string) *fileTagger {
 	return &fileTagger{
 		file: filepath.Join(dir, tagFileName),
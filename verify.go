@@ -0,0 +1,148 @@
+package prj
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+type VerifyResult struct {
+	// The mark the project was verified against, or nil if it has no marks.
+	Entry *LogEntry
+
+	// Number of files from the mark that were checked.
+	Checked int
+
+	// Files whose content changed even though their size and modification
+	// time did not; the usual sign of bit-rot.
+	Rotted []ResourcePath
+
+	// Files whose content, size or modification time changed. These were
+	// probably changed on purpose, so are not counted as problems.
+	Modified []ResourcePath
+
+	// Files in the mark that no longer exist.
+	Missing []ResourcePath
+
+	// Status files in '.prj/status' that could not be read.
+	BadStatusFiles []VerifyFileError
+}
+
+type VerifyFileError struct {
+	File string
+	Err  error
+}
+
+// OK is false if the result contains rotted or missing files, or unreadable
+// status files.
+func (r *VerifyResult) OK() bool {
+	return len(r.Rotted) == 0 && len(r.Missing) == 0 && len(r.BadStatusFiles) == 0
+}
+
+// Verify re-hashes every file in the project and compares it to the last
+// mark, to find damage the user didn't cause. The hash cache can't be
+// trusted for this, as bit-rot doesn't change a file's size or mtime, so
+// StatusOptions.Paranoid is always set.
+func (s *SimpleProject) Verify(ctx context.Context, at time.Time, options *StatusOptions) (*VerifyResult, error) {
+	if err := s.refreshConfig(); err != nil {
+		return nil, err
+	}
+
+	var result VerifyResult
+
+	bad, err := s.verifyStatusFiles()
+	if err != nil {
+		return nil, err
+	}
+	result.BadStatusFiles = bad
+
+	if s.config.LastEntry == nil {
+		return &result, nil
+	}
+	result.Entry = s.config.LastEntry
+
+	mark, err := s.MarkStatus(s.config.LastEntry)
+	if err != nil {
+		// Don't report the same file twice if it exists but can't be read:
+		for _, b := range result.BadStatusFiles {
+			if b.File == s.config.LastEntry.StatusFile {
+				return &result, nil
+			}
+		}
+		result.BadStatusFiles = append(result.BadStatusFiles, VerifyFileError{File: s.config.LastEntry.StatusFile, Err: err})
+		return &result, nil
+	}
+	if err := checkMarkAlgorithm(s.config.LastEntry, mark, s.config.hashAlgorithm()); err != nil {
+		return nil, err
+	}
+	if mark, err = s.FilterIgnored(mark, at); err != nil {
+		return nil, err
+	}
+
+	var statusOptions StatusOptions
+	if options != nil {
+		statusOptions = *options
+	}
+	statusOptions.Paranoid = true
+
+	current, err := s.Status(ctx, "", at, &statusOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	currentIndex := make(map[ResourcePath]*ProjectFile, len(current.Files))
+	for i := range current.Files {
+		currentIndex[current.Files[i].Name] = &current.Files[i]
+	}
+
+	for _, marked := range mark.Files {
+		if marked.SubProject != nil {
+			continue // Verify the sub-project separately.
+		}
+		result.Checked++
+
+		cur := currentIndex[marked.Name]
+		if cur == nil {
+			result.Missing = append(result.Missing, marked.Name)
+			continue
+		}
+
+		if eq, err := cur.Hash.Equal(marked.Hash); err != nil {
+			return nil, fmt.Errorf("prj: could not verify %q: %w", marked.Name, err)
+		} else if eq {
+			continue
+		}
+
+		if cur.Size == marked.Size && cur.ModTime.Equal(marked.ModTime) {
+			result.Rotted = append(result.Rotted, marked.Name)
+		} else {
+			result.Modified = append(result.Modified, marked.Name)
+		}
+	}
+
+	return &result, nil
+}
+
+func (s *SimpleProject) verifyStatusFiles() ([]VerifyFileError, error) {
+	files, err := ioutil.ReadDir(s.statusPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var bad []VerifyFileError
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		if _, err := s.readStatusFile(fi.Name()); err != nil {
+			bad = append(bad, VerifyFileError{File: fi.Name(), Err: err})
+		}
+	}
+	sort.Slice(bad, func(i, j int) bool { return bad[i].File < bad[j].File })
+	return bad, nil
+}